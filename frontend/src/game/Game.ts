@@ -1,7 +1,15 @@
-import { gameStats } from "$lib/stores.svelte";
+import { connectionState, gameStats } from "$lib/stores.svelte";
 import Input from "./Input";
 import WasmWorker from "./wasm/WasmWorker?worker";
 import Renderer, { DEBUG_GRAPHICS_TIME } from "./Renderer";
+import { camera } from "./Camera";
+
+// input buttons, must match the go movement package's
+const BUTTON_FORWARD = 1 << 0;
+const BUTTON_BACK = 1 << 1;
+const BUTTON_LEFT = 1 << 2;
+const BUTTON_RIGHT = 1 << 3;
+const BUTTON_JUMP = 1 << 4;
 
 export type RenderContext = {
 	adapter: GPUAdapter;
@@ -19,6 +27,7 @@ if (import.meta.hot) {
 export default class Game {
 	private input: Input;
 	private worker: Worker;
+	private renderer: Renderer | null = null;
 
 	private frameTime: number = 0;
 	private graphicsTime: { [key: string]: number } | null = null;
@@ -40,6 +49,7 @@ export default class Game {
 				window.addEventListener("resize", resize);
 
 				renderer = new Renderer(canvas, ctx);
+				this.renderer = renderer;
 
 				if (renderer.timestampData) {
 					this.graphicsTime = { ...renderer.timestampData.data };
@@ -56,6 +66,9 @@ export default class Game {
 
 					const startTime = performance.now();
 					renderer.draw(this.input, deltaTime);
+					this.sendInput();
+					// ask for the remote entities' transforms for the next frame
+					this.worker.postMessage({ type: "frame" });
 
 					const endTime = performance.now();
 					this.frameTime += deltaTime;
@@ -96,11 +109,42 @@ export default class Game {
 		worker.onmessage = (e) => {
 			if (e.data === "socket closed") {
 				worker.terminate();
+			} else if (e.data === "update required") {
+				// the server moved on to a newer protocol than this bundle speaks
+				connectionState.updateRequired = true;
+				worker.terminate();
+			} else if (e.data?.type === "player") {
+				this.renderer?.setPlayerPosition(e.data.position, e.data.duration);
+			} else if (e.data?.type === "entities") {
+				this.renderer?.setRemoteEntities(e.data.ids, e.data.models, e.data.transforms);
+			} else if (e.data?.type === "chat") {
+				console.log(`${e.data.name}: ${e.data.text}`);
 			}
 		};
 		this.worker = worker;
 	}
 
+	/**
+	 * Sends the player's controls to the server through the wasm worker
+	 */
+	private sendInput() {
+		const input = this.input;
+		let buttons = 0;
+		if (input.keyDown("W") || input.keyDown("w")) buttons |= BUTTON_FORWARD;
+		if (input.keyDown("S") || input.keyDown("s")) buttons |= BUTTON_BACK;
+		if (input.keyDown("A") || input.keyDown("a")) buttons |= BUTTON_LEFT;
+		if (input.keyDown("D") || input.keyDown("d")) buttons |= BUTTON_RIGHT;
+		if (input.keyDown(" ")) buttons |= BUTTON_JUMP;
+		this.worker.postMessage({ type: "input", buttons, yaw: camera.yaw, pitch: camera.pitch });
+	}
+
+	/**
+	 * Sends a chat message to everyone in the room through the wasm worker
+	 */
+	public sendChat(text: string) {
+		this.worker.postMessage({ type: "chat", text });
+	}
+
 	public onDestroy() {
 		this.input.onDestroy();
 		this.worker.terminate();
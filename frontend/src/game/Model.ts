@@ -55,6 +55,11 @@ export default class Model {
 	}
 }
 
+/**
+ * Loads a model converted by utils/bin-obj onto the GPU.
+ * The file is checked the same way the converter's decodeModel checks it: every count against the rest of the file
+ * and every index against the vertex count, so a corrupt file fails to load instead of reading out of bounds.
+ */
 export async function loadBOBJ(device: GPUDevice, url: string): Promise<ModelData> {
 	const startTime = performance.now();
 	const debug = false;
@@ -158,6 +163,12 @@ export async function loadBOBJ(device: GPUDevice, url: string): Promise<ModelDat
 
 		function appendChunks({ done, value }: ReadableStreamReadResult<Uint8Array<ArrayBufferLike>>): any {
 			if (done || !value) {
+				if (readState !== ModelReadState.Done) {
+					throw new Error("file truncated");
+				}
+				if (trailingChunkData && trailingChunkData.length > 0) {
+					throw new Error(`${trailingChunkData.length} unexpected bytes after the indices`);
+				}
 				return;
 			}
 			if (trailingChunkData && trailingChunkData.length > 0) {
@@ -175,13 +186,19 @@ export async function loadBOBJ(device: GPUDevice, url: string): Promise<ModelDat
 				readState = ModelReadState.VertexComponents;
 
 				indexSize = view.getUint8(0);
+				if (indexSize !== 1 && indexSize !== 2 && indexSize !== 4) {
+					throw new Error(`invalid index size ${indexSize}`);
+				}
 				if (debug) console.log("index size:", indexSize);
 				readIndex += 1;
 			}
-			if (readState === ModelReadState.VertexComponents && chunkSize >= 1) {
+			if (readState === ModelReadState.VertexComponents && chunkSize - readIndex >= 1) {
 				readState = ModelReadState.ScaleFactor;
 
 				const componentMask = view.getUint8(readIndex);
+				if ((componentMask & ~0x7) !== 0) {
+					throw new Error(`invalid component mask 0x${componentMask.toString(16)}`);
+				}
 				hasColor = (componentMask & 0x4) !== 0;
 				hasNormal = (componentMask & 0x2) !== 0;
 				hasUV = (componentMask & 0x1) !== 0;
@@ -214,7 +231,13 @@ export async function loadBOBJ(device: GPUDevice, url: string): Promise<ModelDat
 				readState = ModelReadState.IndexCount;
 
 				vertexBufferSize = view.getUint32(readIndex, true);
-				vertexCount = vertexBufferSize / (hasUV && hasNormal ? 4 : hasUV || hasNormal ? 3 : 2);
+				const stride = hasUV && hasNormal ? 4 : hasUV || hasNormal ? 3 : 2;
+				if (vertexBufferSize % stride !== 0) {
+					throw new Error(
+						`vertex buffer of ${vertexBufferSize} words isn't a whole number of ${stride} word vertices`,
+					);
+				}
+				vertexCount = vertexBufferSize / stride;
 				vertices = new Uint32Array(vertexBufferSize);
 				if (debug) {
 					console.log("vertex buffer size (bytes):", vertexBufferSize * 4);
@@ -226,6 +249,9 @@ export async function loadBOBJ(device: GPUDevice, url: string): Promise<ModelDat
 				readState = ModelReadState.VertexData;
 
 				indexCount = view.getUint32(readIndex, true);
+				if (indexCount % 3 !== 0) {
+					throw new Error(`${indexCount} indices aren't a whole number of triangles`);
+				}
 				triangleCount = indexCount / 3;
 				switch (indexSize) {
 					case 1:
@@ -241,20 +267,23 @@ export async function loadBOBJ(device: GPUDevice, url: string): Promise<ModelDat
 				if (debug) console.log("triangle count:", triangleCount);
 				readIndex += 4;
 			}
-			while (readState === ModelReadState.VertexData && chunkSize - readIndex >= 4) {
-				if (vertexWriteIndex >= vertexBufferSize) {
-					readState = ModelReadState.IndexData;
-					if (debug) {
-						console.log("done reading vertices");
-						console.log(vertices);
-					}
-					break;
-				}
+			while (
+				readState === ModelReadState.VertexData &&
+				vertexWriteIndex < vertexBufferSize &&
+				chunkSize - readIndex >= 4
+			) {
 				const vertex = view.getUint32(readIndex, true);
 				vertices[vertexWriteIndex] = vertex;
 				vertexWriteIndex += 1;
 				readIndex += 4;
 			}
+			if (readState === ModelReadState.VertexData && vertexWriteIndex >= vertexBufferSize) {
+				readState = indexCount > 0 ? ModelReadState.IndexData : ModelReadState.Done;
+				if (debug) {
+					console.log("done reading vertices");
+					console.log(vertices);
+				}
+			}
 			while (
 				readState === ModelReadState.IndexData &&
 				chunkSize - readIndex >= indexSize &&
@@ -272,6 +301,9 @@ export async function loadBOBJ(device: GPUDevice, url: string): Promise<ModelDat
 						index = view.getUint32(readIndex, true);
 						break;
 				}
+				if (index >= vertexCount) {
+					throw new Error(`index ${index} of ${vertexCount} vertices`);
+				}
 				indices[indicesWriteIndex] = index;
 				indicesWriteIndex += 1;
 				readIndex += indexSize;
@@ -1,5 +1,5 @@
 import Camera from "./Camera";
-import { mat4, vec2, vec3, vec4, type Mat4, type Vec2, type Vec3, type Vec4 } from "wgpu-matrix";
+import { mat4, quat, vec2, vec3, vec4, type Mat4, type Vec2, type Vec3, type Vec4 } from "wgpu-matrix";
 import type Input from "./Input";
 import type { RenderContext } from "./Game";
 import { loadShaders, type Shaders } from "./Shaders";
@@ -262,6 +262,19 @@ export default class Renderer {
 	private readonly vel: Vec3 = vec3.create();
 	private readonly accelY: Vec3 = vec3.create();
 
+	// the local player as predicted by the wasm worker, eased towards over one server tick
+	private readonly player = {
+		from: vec3.create(),
+		to: vec3.create(),
+		start: 0,
+		duration: 0,
+		active: false,
+	};
+
+	// scene objects of the remote entities, by entity ID, and hidden ones kept for reuse, by model ID
+	private readonly remoteObjects = new Map<number, { model: number; object: SceneObject }>();
+	private readonly spareObjects = new Map<number, SceneObject[]>();
+
 	constructor(canvas: HTMLCanvasElement, context: RenderContext) {
 		this.canvas = canvas;
 		this.device = context.device;
@@ -2406,6 +2419,90 @@ export default class Renderer {
 		cullPass.end();
 	}
 
+	/**
+	 * Moves the camera to the local player's predicted position
+	 * @param position predicted position
+	 * @param duration milliseconds to ease to the position over, one server tick
+	 */
+	public setPlayerPosition(position: number[], duration: number) {
+		vec3.copy(this.camera.position, this.player.from);
+		vec3.set(position[0], position[1], position[2], this.player.to);
+		this.player.start = performance.now();
+		this.player.duration = duration;
+		this.player.active = true;
+	}
+
+	/**
+	 * Places the remote entities, hiding the objects of entities that are gone
+	 * @param ids entity IDs
+	 * @param models model ID of each entity, matching the backend's
+	 * @param transforms position x, y, z and rotation quaternion x, y, z, w of each entity
+	 */
+	public setRemoteEntities(ids: Uint32Array, models: Uint8Array, transforms: Float32Array) {
+		if (!this.resources) {
+			return;
+		}
+		const meshes = [this.resources.cube, this.resources.monke];
+		const seen = new Set<number>();
+		let added = false;
+		for (let i = 0; i < ids.length; i++) {
+			const id = ids[i];
+			const model = models[i];
+			if (!meshes[model]) {
+				continue;
+			}
+			seen.add(id);
+			let remote = this.remoteObjects.get(id);
+			if (remote && remote.model !== model) {
+				this.hideRemoteObject(id);
+				remote = undefined;
+			}
+			if (!remote) {
+				let object = this.spareObjects.get(model)?.pop();
+				if (!object) {
+					object = this.addObject(new Model({ mesh: meshes[model], castShadows: true }), "dynamic");
+					object.model.transform.orientation = quat.create();
+					added = true;
+				}
+				remote = { model, object };
+				this.remoteObjects.set(id, remote);
+			}
+			const transform = remote.object.model.transform;
+			vec3.set(transforms[i * 7], transforms[i * 7 + 1], transforms[i * 7 + 2], transform.position);
+			quat.set(
+				transforms[i * 7 + 3],
+				transforms[i * 7 + 4],
+				transforms[i * 7 + 5],
+				transforms[i * 7 + 6],
+				transform.orientation!,
+			);
+			vec3.set(1, 1, 1, transform.scale);
+		}
+		for (const id of this.remoteObjects.keys()) {
+			if (!seen.has(id)) {
+				this.hideRemoteObject(id);
+			}
+		}
+		if (added) {
+			this.updateRenderBundles();
+		}
+	}
+
+	/**
+	 * Hides a remote entity's object, since objects can't be removed, and keeps it to reuse for another entity
+	 */
+	private hideRemoteObject(id: number) {
+		const remote = this.remoteObjects.get(id);
+		if (!remote) {
+			return;
+		}
+		vec3.set(0, 0, 0, remote.object.model.transform.scale);
+		this.remoteObjects.delete(id);
+		const spares = this.spareObjects.get(remote.model) ?? [];
+		spares.push(remote.object);
+		this.spareObjects.set(remote.model, spares);
+	}
+
 	public draw(input: Input, deltaTime: number) {
 		for (const obj of this.objects) {
 			if (obj.usage !== "dynamic") {
@@ -2430,33 +2527,42 @@ export default class Renderer {
 				);
 			}
 
-			// update input vector
-			this.inputVec[0] =
-				(input.keyDown("D") || input.keyDown("d") ? 1 : 0) - (input.keyDown("A") || input.keyDown("a") ? 1 : 0);
-			(this.inputVec[1] =
-				(input.keyDown("W") || input.keyDown("w") ? 1 : 0) -
-				(input.keyDown("S") || input.keyDown("s") ? 1 : 0)),
-				vec2.normalize(this.inputVec, this.inputVec);
-
-			// update acceleration, velocity and position
-			vec3.scale(this.camera.right, -this.inputVec[0], this.accel);
-			vec3.scale(this.camera.forward, this.inputVec[1], this.accelY);
-			vec3.add(this.accel, this.accelY, this.accel);
-
-			this.vel[0] = Math.min(
-				MAX_VEL,
-				Math.max(-MAX_VEL, this.vel[0] + (this.accel[0] - this.vel[0]) * ACCEL * deltaTime),
-			);
-			this.vel[1] = Math.min(
-				MAX_VEL,
-				Math.max(-MAX_VEL, this.vel[1] + (this.accel[1] - this.vel[1]) * ACCEL * deltaTime),
-			);
-			this.vel[2] = Math.min(
-				MAX_VEL,
-				Math.max(-MAX_VEL, this.vel[2] + (this.accel[2] - this.vel[2]) * ACCEL * deltaTime),
-			);
+			if (this.player.active) {
+				// follow the server's player, predicted ahead by the wasm worker
+				const t =
+					this.player.duration > 0
+						? Math.min(1, (performance.now() - this.player.start) / this.player.duration)
+						: 1;
+				vec3.lerp(this.player.from, this.player.to, t, this.camera.position);
+			} else {
+				// update input vector
+				this.inputVec[0] =
+					(input.keyDown("D") || input.keyDown("d") ? 1 : 0) - (input.keyDown("A") || input.keyDown("a") ? 1 : 0);
+				(this.inputVec[1] =
+					(input.keyDown("W") || input.keyDown("w") ? 1 : 0) -
+					(input.keyDown("S") || input.keyDown("s") ? 1 : 0)),
+					vec2.normalize(this.inputVec, this.inputVec);
+
+				// update acceleration, velocity and position
+				vec3.scale(this.camera.right, -this.inputVec[0], this.accel);
+				vec3.scale(this.camera.forward, this.inputVec[1], this.accelY);
+				vec3.add(this.accel, this.accelY, this.accel);
+
+				this.vel[0] = Math.min(
+					MAX_VEL,
+					Math.max(-MAX_VEL, this.vel[0] + (this.accel[0] - this.vel[0]) * ACCEL * deltaTime),
+				);
+				this.vel[1] = Math.min(
+					MAX_VEL,
+					Math.max(-MAX_VEL, this.vel[1] + (this.accel[1] - this.vel[1]) * ACCEL * deltaTime),
+				);
+				this.vel[2] = Math.min(
+					MAX_VEL,
+					Math.max(-MAX_VEL, this.vel[2] + (this.accel[2] - this.vel[2]) * ACCEL * deltaTime),
+				);
 
-			vec3.addScaled(this.camera.position, this.vel, 0.01 * deltaTime, this.camera.position);
+				vec3.addScaled(this.camera.position, this.vel, 0.01 * deltaTime, this.camera.position);
+			}
 		}
 
 		// update camera
@@ -1,10 +1,14 @@
-import { mat3, mat4, quat, vec3, vec4, type Vec3 } from "wgpu-matrix";
+import { mat3, mat4, quat, vec3, vec4, type Quat, type Vec3 } from "wgpu-matrix";
 import type Camera from "./Camera";
 
 export default class Transform {
 	public position = vec3.create();
 	public rotation = vec3.create();
 	public scale = vec3.fromValues(1, 1, 1);
+	/**
+	 * when set, used as the rotation instead of the euler angles
+	 */
+	public orientation: Quat | null = null;
 
 	public readonly matrix = mat4.create();
 	public readonly normalMatrix = mat3.create();
@@ -23,7 +27,11 @@ export default class Transform {
 	}
 
 	public update(camera: Camera) {
-		quat.fromEuler(this.rotation[0], this.rotation[1], this.rotation[2], "xyz", this.quaternion);
+		if (this.orientation) {
+			quat.copy(this.orientation, this.quaternion);
+		} else {
+			quat.fromEuler(this.rotation[0], this.rotation[1], this.rotation[2], "xyz", this.quaternion);
+		}
 		mat4.identity(this.matrix);
 		mat4.translation(this.position, this.matrix);
 		mat4.fromQuat(this.quaternion, this.rotationMatrix);
@@ -0,0 +1,475 @@
+// Code generated by protogen from schema.json. DO NOT EDIT.
+
+// Decoder of the messages the server sends, matching the go protocol package.
+
+/** ResumeTokenSize is the size of a session's resume token. */
+export const ResumeTokenSize = 16;
+/** NoOwner is the owner of entities that don't belong to a client. */
+export const NoOwner = 65535;
+
+// server message types, event messages are only sent inside a union field like Event's
+export const MsgWelcome = 1;
+export const MsgJoined = 2;
+export const MsgLeft = 3;
+export const MsgRoster = 4;
+export const MsgLatencies = 5;
+export const MsgTime = 6;
+export const MsgSnapshot = 7;
+export const MsgEvent = 8;
+export const MsgChat = 9;
+export const MsgKill = 10;
+export const MsgPickup = 11;
+
+// EntityUpdate fields, set in its fields mask when they're encoded
+export const FieldOwner = 1;
+export const FieldModel = 2;
+export const FieldPosition = 4;
+export const FieldRotation = 8;
+export const FieldVelocity = 16;
+export const FieldInputSeq = 32;
+export const FieldsAll = FieldOwner | FieldModel | FieldPosition | FieldRotation | FieldVelocity | FieldInputSeq;
+
+export class ProtocolError extends Error {
+	constructor(message: string) {
+		super("protocol: " + message);
+	}
+}
+
+const textDecoder = new TextDecoder();
+
+class Reader {
+	private view: DataView;
+	private offset = 0;
+
+	constructor(private data: Uint8Array) {
+		this.view = new DataView(data.buffer, data.byteOffset, data.byteLength);
+	}
+
+	private take(n: number): number {
+		if (this.offset + n > this.data.byteLength) throw new ProtocolError("message truncated");
+		const offset = this.offset;
+		this.offset += n;
+		return offset;
+	}
+
+	uint8(): number {
+		return this.view.getUint8(this.take(1));
+	}
+
+	uint16(): number {
+		return this.view.getUint16(this.take(2), true);
+	}
+
+	uint32(): number {
+		return this.view.getUint32(this.take(4), true);
+	}
+
+	float32(): number {
+		return this.view.getFloat32(this.take(4), true);
+	}
+
+	float64(): number {
+		return this.view.getFloat64(this.take(8), true);
+	}
+
+	bool(): boolean {
+		const v = this.uint8();
+		if (v > 1) throw new ProtocolError("invalid field");
+		return v === 1;
+	}
+
+	string(): string {
+		const n = this.uint8();
+		const offset = this.take(n);
+		return textDecoder.decode(this.data.subarray(offset, offset + n));
+	}
+
+	bytes(n: number): Uint8Array {
+		const offset = this.take(n);
+		return this.data.slice(offset, offset + n);
+	}
+
+	/** reads a little endian value of n bytes */
+	packed(n: number): bigint {
+		const offset = this.take(n);
+		let v = 0n;
+		for (let i = n - 1; i >= 0; i--) v = (v << 8n) | BigInt(this.data[offset + i]);
+		return v;
+	}
+
+	done() {
+		if (this.offset < this.data.byteLength) throw new ProtocolError("unexpected data after message");
+	}
+}
+
+class Range {
+	readonly steps: number;
+	readonly bits: number;
+
+	constructor(
+		readonly min: number,
+		readonly max: number,
+		readonly precision: number,
+	) {
+		this.steps = Math.round((max - min) / precision);
+		this.bits = 32 - Math.clz32(this.steps);
+	}
+
+	dequantize(q: number): number {
+		return Math.fround(this.min + Math.min(q, this.steps) * this.precision);
+	}
+}
+
+class VectorQuantizer {
+	constructor(readonly ranges: Range[]) {}
+
+	unpack(packed: bigint): number[] {
+		const v = [0, 0, 0];
+		for (let i = this.ranges.length - 1; i >= 0; i--) {
+			const bits = BigInt(this.ranges[i].bits);
+			v[i] = this.ranges[i].dequantize(Number(packed & ((1n << bits) - 1n)));
+			packed >>= bits;
+		}
+		return v;
+	}
+}
+
+class RotationQuantizer {
+	private half: number;
+
+	constructor(readonly bits: number) {
+		this.half = 2 ** (bits - 1) - 1;
+	}
+
+	/** rebuilds the dropped component of a quaternion compressed to its three smallest components */
+	unpack(packed: bigint): number[] {
+		const bits = BigInt(this.bits);
+		const largest = Number((packed >> (3n * bits)) & 3n);
+		const q = [0, 0, 0, 0];
+		let sum = 0;
+		for (let i = 3; i >= 0; i--) {
+			if (i === largest) continue;
+			const v = Number(packed & ((1n << bits) - 1n));
+			packed >>= bits;
+			q[i] = Math.fround(((v - this.half) / this.half) * Math.SQRT1_2);
+			sum += q[i] * q[i];
+		}
+		q[largest] = Math.fround(Math.sqrt(Math.max(0, 1 - sum)));
+		const l = Math.sqrt(q[0] * q[0] + q[1] * q[1] + q[2] * q[2] + q[3] * q[3]);
+		if (l === 0 || Number.isNaN(l)) return [0, 0, 0, 1];
+		return q.map((v) => Math.fround(v / l));
+	}
+}
+
+/** Position covers the level with a precision of 1/1024 units. */
+export const Position = new VectorQuantizer([
+	new Range(-256, 256, 1 / 1024),
+	new Range(-32, 96, 1 / 1024),
+	new Range(-256, 256, 1 / 1024),
+]);
+/** Rotation is within about 0.1 degrees. */
+export const Rotation = new RotationQuantizer(10);
+/** Velocity covers up to 64 units per second with a precision of 1/256 units per second. */
+export const Velocity = new VectorQuantizer([
+	new Range(-64, 64, 1 / 256),
+	new Range(-64, 64, 1 / 256),
+	new Range(-64, 64, 1 / 256),
+]);
+
+/** RosterEntry is a client in the roster. */
+export interface RosterEntry {
+	client: number;
+	name: string;
+}
+
+function readRosterEntry(r: Reader): RosterEntry {
+	const m = {} as RosterEntry;
+	m.client = r.uint16();
+	m.name = r.string();
+	return m;
+}
+
+/** Latency is a client's round trip time in milliseconds. */
+export interface Latency {
+	client: number;
+	rtt: number;
+}
+
+function readLatency(r: Reader): Latency {
+	const m = {} as Latency;
+	m.client = r.uint16();
+	m.rtt = r.uint16();
+	return m;
+}
+
+/**
+ * EntityUpdate is a new or changed entity in a snapshot. Only the fields in the mask are encoded,
+ * new entities have every field.
+ */
+export interface EntityUpdate {
+	id: number;
+	fields: number;
+	owner?: number;
+	model?: number;
+	position?: number[];
+	rotation?: number[];
+	velocity?: number[];
+	inputSeq?: number;
+}
+
+function readEntityUpdate(r: Reader): EntityUpdate {
+	const m = {} as EntityUpdate;
+	m.id = r.uint32();
+	m.fields = r.uint8();
+	if ((m.fields & ~FieldsAll) !== 0) throw new ProtocolError("invalid field");
+	if ((m.fields & FieldOwner) !== 0) {
+		m.owner = r.uint16();
+	}
+	if ((m.fields & FieldModel) !== 0) {
+		m.model = r.uint8();
+	}
+	if ((m.fields & FieldPosition) !== 0) {
+		m.position = Position.unpack(r.packed(8));
+	}
+	if ((m.fields & FieldRotation) !== 0) {
+		m.rotation = Rotation.unpack(r.packed(4));
+	}
+	if ((m.fields & FieldVelocity) !== 0) {
+		m.velocity = Velocity.unpack(r.packed(6));
+	}
+	if ((m.fields & FieldInputSeq) !== 0) {
+		m.inputSeq = r.uint32();
+	}
+	return m;
+}
+
+/** Welcome is the first message a client gets after registering or resuming. */
+export interface Welcome {
+	type: typeof MsgWelcome;
+	client: number;
+	resumed: boolean;
+	token: Uint8Array;
+}
+
+function readWelcome(r: Reader): Welcome {
+	const m = { type: MsgWelcome } as Welcome;
+	m.client = r.uint16();
+	m.resumed = r.bool();
+	m.token = r.bytes(16);
+	return m;
+}
+
+/** Joined is a client joining the game. */
+export interface Joined {
+	type: typeof MsgJoined;
+	client: number;
+	name: string;
+}
+
+function readJoined(r: Reader): Joined {
+	const m = { type: MsgJoined } as Joined;
+	m.client = r.uint16();
+	m.name = r.string();
+	return m;
+}
+
+/** Left is a client leaving the game. */
+export interface Left {
+	type: typeof MsgLeft;
+	client: number;
+	reason: number;
+}
+
+function readLeft(r: Reader): Left {
+	const m = { type: MsgLeft } as Left;
+	m.client = r.uint16();
+	m.reason = r.uint8();
+	return m;
+}
+
+/** Roster is every client in the game, sent to new clients when they join. */
+export interface Roster {
+	type: typeof MsgRoster;
+	clients: RosterEntry[];
+}
+
+function readRoster(r: Reader): Roster {
+	const m = { type: MsgRoster } as Roster;
+	m.clients = [];
+	for (let n = r.uint16(); n > 0; n--) m.clients.push(readRosterEntry(r));
+	return m;
+}
+
+/** Latencies is every client's round trip time, for the scoreboard. */
+export interface Latencies {
+	type: typeof MsgLatencies;
+	clients: Latency[];
+}
+
+function readLatencies(r: Reader): Latencies {
+	const m = { type: MsgLatencies } as Latencies;
+	m.clients = [];
+	for (let n = r.uint16(); n > 0; n--) m.clients.push(readLatency(r));
+	return m;
+}
+
+/**
+ * Time is the answer to a clock sync request.
+ * All server times are in milliseconds since the server started.
+ */
+export interface Time {
+	type: typeof MsgTime;
+	clientSent: number;
+	serverReceived: number;
+	serverSent: number;
+	tickOrigin: number;
+	tickInterval: number;
+}
+
+function readTime(r: Reader): Time {
+	const m = { type: MsgTime } as Time;
+	m.clientSent = r.float64();
+	m.serverReceived = r.float64();
+	m.serverSent = r.float64();
+	m.tickOrigin = r.float64();
+	m.tickInterval = r.float64();
+	return m;
+}
+
+/**
+ * Snapshot is the changes to the replicated entities since the baseline snapshot,
+ * or every entity if it's a full snapshot. The snapshot's tick is the one in its header.
+ */
+export interface Snapshot {
+	type: typeof MsgSnapshot;
+	baseline: number;
+	entities: EntityUpdate[];
+	removed: number[];
+}
+
+function readSnapshot(r: Reader): Snapshot {
+	const m = { type: MsgSnapshot } as Snapshot;
+	m.baseline = r.uint32();
+	m.entities = [];
+	for (let n = r.uint16(); n > 0; n--) m.entities.push(readEntityUpdate(r));
+	m.removed = [];
+	for (let n = r.uint16(); n > 0; n--) m.removed.push(r.uint32());
+	return m;
+}
+
+/**
+ * Event is a reliable event, delivered in order and exactly once.
+ * Every event in a room gets the next sequence number, clients acknowledge the latest one they received with EventAck.
+ * The server keeps sending a client's unacknowledged events after it resumes its session,
+ * so a client can get events it already has again and must skip those it's seen.
+ */
+export interface Event {
+	type: typeof MsgEvent;
+	seq: number;
+	event: Joined | Left | Roster | Chat | Kill | Pickup;
+}
+
+function readEvent(r: Reader): Event {
+	const m = { type: MsgEvent } as Event;
+	m.seq = r.uint32();
+	switch (r.uint8()) {
+		case MsgJoined:
+			m.event = readJoined(r);
+			break;
+		case MsgLeft:
+			m.event = readLeft(r);
+			break;
+		case MsgRoster:
+			m.event = readRoster(r);
+			break;
+		case MsgChat:
+			m.event = readChat(r);
+			break;
+		case MsgKill:
+			m.event = readKill(r);
+			break;
+		case MsgPickup:
+			m.event = readPickup(r);
+			break;
+		default:
+			throw new ProtocolError("unknown message type");
+	}
+	return m;
+}
+
+/** Chat is a chat message from a client. */
+export interface Chat {
+	type: typeof MsgChat;
+	client: number;
+	text: string;
+}
+
+function readChat(r: Reader): Chat {
+	const m = { type: MsgChat } as Chat;
+	m.client = r.uint16();
+	m.text = r.string();
+	return m;
+}
+
+/** Kill is a client's player killing another's. */
+export interface Kill {
+	type: typeof MsgKill;
+	killer: number;
+	victim: number;
+}
+
+function readKill(r: Reader): Kill {
+	const m = { type: MsgKill } as Kill;
+	m.killer = r.uint16();
+	m.victim = r.uint16();
+	return m;
+}
+
+/** Pickup is a client's player picking up an item. */
+export interface Pickup {
+	type: typeof MsgPickup;
+	client: number;
+	item: number;
+	kind: number;
+}
+
+function readPickup(r: Reader): Pickup {
+	const m = { type: MsgPickup } as Pickup;
+	m.client = r.uint16();
+	m.item = r.uint32();
+	m.kind = r.uint8();
+	return m;
+}
+
+export type ServerMessage = Welcome | Latencies | Time | Snapshot | Event;
+
+/**
+ * Decodes a server message along with the tick it was sent on, throwing a ProtocolError if it's invalid
+ */
+export function decode(data: ArrayBuffer | Uint8Array): { tick: number; message: ServerMessage } {
+	const r = new Reader(data instanceof Uint8Array ? data : new Uint8Array(data));
+	const type = r.uint8();
+	const tick = r.uint32();
+	let message: ServerMessage;
+	switch (type) {
+		case MsgWelcome:
+			message = readWelcome(r);
+			break;
+		case MsgLatencies:
+			message = readLatencies(r);
+			break;
+		case MsgTime:
+			message = readTime(r);
+			break;
+		case MsgSnapshot:
+			message = readSnapshot(r);
+			break;
+		case MsgEvent:
+			message = readEvent(r);
+			break;
+		default:
+			throw new ProtocolError("unknown message type");
+	}
+	r.done();
+	return { tick, message };
+}
@@ -1,13 +1,28 @@
 import "./wasm_exec";
 import init from "./main.wasm?init";
 
+const global = globalThis as any;
+
 onmessage = (e: MessageEvent) => {
-	console.log("wasm wrapper received ", e.data);
-	// input and stuff will go here prolly
+	switch (e.data?.type) {
+		case "input":
+			// not defined until the wasm module starts
+			global.setInput?.(e.data.buttons, e.data.yaw, e.data.pitch);
+			break;
+		case "frame":
+			global.interpolate?.();
+			break;
+		case "interpolation delay":
+			global.setInterpolationDelay?.(e.data.delay);
+			break;
+		case "chat":
+			global.sendChat?.(e.data.text);
+			break;
+		default:
+			console.log("wasm wrapper received ", e.data);
+	}
 };
 
-const global = globalThis as any;
-
 global.onSocketOpen = () => {
 	postMessage("socket open");
 };
@@ -16,6 +31,27 @@ global.onSocketClose = () => {
 	postMessage("socket closed");
 };
 
+global.onUpdateRequired = () => {
+	postMessage("update required");
+};
+
+global.onChat = (name: string, text: string) => {
+	postMessage({ type: "chat", name, text });
+};
+
+global.onEntities = (ids: Uint32Array, models: Uint8Array, transforms: Float32Array) => {
+	postMessage({ type: "entities", ids, models, transforms }, [ids.buffer, models.buffer, transforms.buffer]);
+};
+
+global.onPlayerMoved = (x: number, y: number, z: number, duration: number) => {
+	postMessage({ type: "player", position: [x, y, z], duration });
+};
+
+// "json" sends messages to the server as JSON text frames, to read them in the devtools.
+// The server has to run with -json-wire to accept them, set VITE_WIRE_FORMAT=json to turn it on.
+// The wasm has to be built with the same VITE_WIRE_FORMAT too, only its jsonwire build can encode JSON.
+global.wireFormat = import.meta.env.VITE_WIRE_FORMAT;
+
 const runWasm = async () => {
 	// @ts-ignore
 	const go = new Go();
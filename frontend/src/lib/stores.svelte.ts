@@ -9,3 +9,9 @@ export const gameStats = $state<{
 	fps: 0,
 	passes: {},
 });
+
+export const connectionState = $state<{
+	updateRequired: boolean;
+}>({
+	updateRequired: false,
+});
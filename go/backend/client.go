@@ -0,0 +1,477 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"expvar"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/lxzan/gws"
+
+	"webgl-multiplayer/protocol"
+)
+
+const (
+	ResumeTokenSize  = protocol.ResumeTokenSize
+	MaxUnackedEvents = 256 // events kept for a client at most, clients that fall further behind lose their session
+)
+
+// epoch is the server's start time, heartbeats are stamped relative to it.
+var epoch = time.Now()
+
+// Send queue and client counters for all clients, served on /debug/vars.
+var (
+	queuedPackets    = expvar.NewInt("outbound_queued")
+	sentPackets      = expvar.NewInt("outbound_sent")
+	droppedPackets   = expvar.NewInt("outbound_dropped")
+	coalescedPackets = expvar.NewInt("outbound_coalesced")
+	evictedClients   = expvar.NewInt("clients_evicted")
+	invalidMessages  = expvar.NewInt("inbound_invalid")
+)
+
+type CID uint16
+
+// Client is a player registered with the hub.
+// Outbound messages go through the client's own bounded send queue, drained by its writer goroutine,
+// so a slow client only holds up itself.
+// When the connection drops, the client is suspended until it resumes on a new connection or its grace period ends.
+type Client struct {
+	ID    CID
+	Name  string
+	token string
+
+	mu      sync.Mutex
+	conn    *gws.Conn // nil while suspended
+	queue   []*packet
+	closed  bool
+	notify  chan struct{}
+	quit    chan struct{}
+	evicted atomic.Bool
+	expiry  *time.Timer
+
+	suspended bool // owned by the hub's run loop
+
+	// events are the events sent to the client that it hasn't acknowledged yet, oldest first.
+	// They're guarded by the queue lock.
+	events []*event
+
+	sent      atomic.Uint64
+	dropped   atomic.Uint64
+	coalesced atomic.Uint64
+
+	latency sync.Mutex
+	rtt     time.Duration // smoothed round trip time
+	jitter  time.Duration // smoothed variation between consecutive round trips
+	lastRTT time.Duration
+	missed  atomic.Int32 // pings sent since the last pong
+
+	decodeErrors atomic.Int32 // messages from the client that couldn't be decoded, over its whole session
+}
+
+// QueueStats are the counters of a client's send queue.
+type QueueStats struct {
+	Queued    int
+	Sent      uint64
+	Dropped   uint64
+	Coalesced uint64
+}
+
+// packet is an outbound message shared by the send queues of all its recipients.
+// The payload is compressed once, and the frame is released once every recipient wrote or dropped it.
+type packet struct {
+	b      *gws.Broadcaster
+	state  bool
+	event  *event // set if the packet is a reliable event
+	queued time.Time
+	refs   atomic.Int32
+}
+
+// event is a reliable event, kept by each recipient until it's acknowledged.
+type event struct {
+	seq     uint32
+	payload []byte
+}
+
+// newPacket creates a packet holding a single reference, which the creator must release.
+func newPacket(opcode gws.Opcode, payload []byte, state bool) *packet {
+	p := &packet{
+		b:      gws.NewBroadcaster(opcode, payload),
+		state:  state,
+		queued: time.Now(),
+	}
+	p.refs.Store(1)
+	return p
+}
+
+// newEventPacket creates a packet for an event, holding a single reference like newPacket.
+func newEventPacket(e *event) *packet {
+	p := newPacket(wireOpcode(), e.payload, false)
+	p.event = e
+	return p
+}
+
+func (p *packet) release() {
+	if p.refs.Add(-1) == 0 {
+		p.b.Close()
+	}
+}
+
+func newClient(id CID, name string, conn *gws.Conn) *Client {
+	if name == "" {
+		name = fmt.Sprintf("Player %d", id)
+	}
+	c := &Client{
+		ID:     id,
+		Name:   name,
+		token:  newResumeToken(),
+		conn:   conn,
+		queue:  make([]*packet, 0, config.QueueSize),
+		notify: make(chan struct{}, 1),
+		quit:   make(chan struct{}),
+	}
+	go c.writeLoop()
+	go c.heartbeatLoop(config.HeartbeatInterval, config.MaxMissedHeartbeats)
+	return c
+}
+
+// Latency returns the client's smoothed round trip time and jitter, zero until the first pong.
+func (c *Client) Latency() (rtt, jitter time.Duration) {
+	c.latency.Lock()
+	defer c.latency.Unlock()
+	return c.rtt, c.jitter
+}
+
+// heartbeatLoop pings the client on an interval, stamping each ping with the time it was sent.
+// A client that misses too many pings in a row has its connection closed, it can still resume.
+// The settings are passed in by newClient rather than read by the loop, so they're only read on the hub's goroutine.
+func (c *Client) heartbeatLoop(interval time.Duration, maxMissed int) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-c.quit:
+			return
+		}
+		c.mu.Lock()
+		conn := c.conn
+		c.mu.Unlock()
+		if conn == nil {
+			continue
+		}
+		// Only pings that went out count as missed, so a limit of zero still gives the client one interval to answer.
+		if int(c.missed.Load()) > maxMissed {
+			if Debug {
+				fmt.Println("client missed heartbeats, id: ", c.ID)
+			}
+			_ = conn.NetConn().Close()
+			continue
+		}
+		payload := binary.LittleEndian.AppendUint64(nil, uint64(time.Since(epoch)))
+		conn.WriteAsync(gws.OpcodePing, payload, nil)
+		c.missed.Add(1)
+	}
+}
+
+// onPong measures the round trip of a ping sent by the heartbeat.
+// The smoothing follows TCP's round trip estimator (RFC 6298) and RTP's interarrival jitter (RFC 3550).
+func (c *Client) onPong(payload []byte) {
+	c.missed.Store(0)
+	if len(payload) != 8 {
+		return
+	}
+	sample := time.Since(epoch) - time.Duration(binary.LittleEndian.Uint64(payload))
+	if sample < 0 {
+		return
+	}
+
+	c.latency.Lock()
+	defer c.latency.Unlock()
+	if c.rtt == 0 {
+		c.rtt = sample
+		c.jitter = sample / 2
+	} else {
+		diff := sample - c.lastRTT
+		if diff < 0 {
+			diff = -diff
+		}
+		c.rtt += (sample - c.rtt) / 8
+		c.jitter += (diff - c.jitter) / 16
+	}
+	c.lastRTT = sample
+}
+
+// Stats returns a snapshot of the client's send queue counters.
+func (c *Client) Stats() QueueStats {
+	c.mu.Lock()
+	queued := len(c.queue)
+	c.mu.Unlock()
+	return QueueStats{
+		Queued:    queued,
+		Sent:      c.sent.Load(),
+		Dropped:   c.dropped.Load(),
+		Coalesced: c.coalesced.Load(),
+	}
+}
+
+// send queues the packet for the client, applying the queue policy when it's full.
+func (c *Client) send(p *packet) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return
+	}
+	if p.event != nil && !c.logEvent(p.event) {
+		return
+	}
+	if c.conn == nil {
+		// Events are sent again once the client resumes, and state updates start over, so nothing's queued.
+		return
+	}
+	if len(c.queue) > 0 && time.Since(c.queue[0].queued) > config.MaxLag {
+		c.evict(c.conn, ReasonSlowClient)
+		return
+	}
+	if config.QueuePolicy == Coalesce && p.state {
+		// Only the latest state matters, older ones still in the queue are superseded.
+		kept := c.queue[:0]
+		for _, q := range c.queue {
+			if q.state {
+				c.coalesced.Add(1)
+				coalescedPackets.Add(1)
+				queuedPackets.Add(-1)
+				q.release()
+			} else {
+				kept = append(kept, q)
+			}
+		}
+		clear(c.queue[len(kept):])
+		c.queue = kept
+	}
+	if len(c.queue) >= config.QueueSize {
+		if config.QueuePolicy == Disconnect || !c.dropOldestState() {
+			c.evict(c.conn, ReasonSlowClient)
+			return
+		}
+	}
+
+	c.push(p)
+}
+
+// logEvent keeps an event until the client acknowledges it.
+// A client that falls too far behind loses its session, since it can't be sent every event anymore.
+// Returns false if the client lost its session.
+// The queue lock must be held.
+func (c *Client) logEvent(e *event) bool {
+	if len(c.events) >= MaxUnackedEvents {
+		if c.conn != nil {
+			c.evict(c.conn, ReasonSlowClient)
+		} else {
+			// Suspended, so there's no connection to close. The session can't be resumed and expires.
+			c.evicted.Store(true)
+		}
+		return false
+	}
+	c.events = append(c.events, e)
+	return true
+}
+
+// ackEvents forgets the events up to and including the sequence number, which the client received.
+func (c *Client) ackEvents(seq uint32) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	i := 0
+	for i < len(c.events) && int32(c.events[i].seq-seq) <= 0 {
+		i++
+	}
+	clear(c.events[:i])
+	c.events = c.events[i:]
+}
+
+// push appends a packet to the queue and wakes up the writer.
+// The queue lock must be held.
+func (c *Client) push(p *packet) {
+	p.refs.Add(1)
+	c.queue = append(c.queue, p)
+	queuedPackets.Add(1)
+	c.wake()
+}
+
+func (c *Client) wake() {
+	select {
+	case c.notify <- struct{}{}:
+	default:
+	}
+}
+
+// dropOldestState drops the oldest queued state update. Returns false if there are none to drop.
+// The queue lock must be held.
+func (c *Client) dropOldestState() bool {
+	for i, q := range c.queue {
+		if q.state {
+			c.queue = append(c.queue[:i], c.queue[i+1:]...)
+			c.dropped.Add(1)
+			droppedPackets.Add(1)
+			queuedPackets.Add(-1)
+			q.release()
+			return true
+		}
+	}
+	return false
+}
+
+// pop removes the next packet from the queue along with the connection to write it to.
+// Returns nil if the queue is empty or the client is suspended.
+func (c *Client) pop() (*packet, *gws.Conn) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed || c.conn == nil || len(c.queue) == 0 {
+		return nil, nil
+	}
+	p := c.queue[0]
+	c.queue[0] = nil
+	c.queue = c.queue[1:]
+	queuedPackets.Add(-1)
+	return p, c.conn
+}
+
+// writeLoop drains the send queue, writing one packet at a time.
+// A write that doesn't complete within the max lag evicts the client.
+func (c *Client) writeLoop() {
+	timer := time.NewTimer(config.MaxLag)
+	defer timer.Stop()
+	for {
+		select {
+		case <-c.notify:
+		case <-c.quit:
+			return
+		}
+		for p, conn := c.pop(); p != nil; p, conn = c.pop() {
+			written := make(chan struct{})
+			_ = p.b.Broadcast(conn)
+			// The connection's write queue runs tasks in order, so this runs once the frame is written.
+			conn.Async(func() { close(written) })
+			deadline := config.MaxLag - time.Since(p.queued)
+			p.release()
+
+			timer.Reset(deadline)
+			select {
+			case <-written:
+				c.sent.Add(1)
+				sentPackets.Add(1)
+			case <-timer.C:
+				c.mu.Lock()
+				c.evict(conn, ReasonSlowClient)
+				c.mu.Unlock()
+			case <-c.quit:
+				return
+			}
+		}
+	}
+}
+
+// evict disconnects a client that can't keep up or misbehaves, if it's still on the given connection.
+// Evicted clients aren't suspended, so they can't resume.
+// The queue lock must be held.
+func (c *Client) evict(conn *gws.Conn, reason string) {
+	if conn == nil || conn != c.conn || !c.evicted.CompareAndSwap(false, true) {
+		return
+	}
+	evictedClients.Add(1)
+	if Debug {
+		fmt.Println("client evicted, id: ", c.ID, ", reason: ", reason, ", queued: ", len(c.queue))
+	}
+	closeConn(conn, ClosePolicyViolation, reason)
+}
+
+// invalidMessage counts a message the client sent on the connection that couldn't be decoded.
+// A few are tolerated, a client that keeps sending them is broken or malicious and gets evicted.
+func (c *Client) invalidMessage(conn *gws.Conn) {
+	invalidMessages.Add(1)
+	if int(c.decodeErrors.Add(1)) <= config.MaxDecodeErrors {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.evict(conn, ReasonInvalidMessages)
+}
+
+// closeConn sends a close frame without waiting for it to be written.
+func closeConn(conn *gws.Conn, code uint16, reason string) {
+	// A stalled write holds the connection's lock, the deadline unblocks it so the close frame can go out.
+	_ = conn.SetWriteDeadline(time.Now().Add(CloseWait))
+	go conn.WriteClose(code, []byte(reason))
+}
+
+// suspend detaches the client from its dropped connection.
+// The queue is dropped, unacknowledged events are sent again once the client resumes.
+func (c *Client) suspend() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.conn = nil
+	c.drain()
+}
+
+// resume attaches the client to a new connection, returning the previous one if it wasn't suspended.
+// The welcome packet is sent first, followed by every event the client hasn't acknowledged.
+// The client may have received some of them already on the previous connection, it skips those.
+func (c *Client) resume(conn *gws.Conn, welcome *packet) *gws.Conn {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.expiry != nil {
+		c.expiry.Stop()
+		c.expiry = nil
+	}
+	prev := c.conn
+	c.conn = conn
+	c.evicted.Store(false)
+	c.missed.Store(0)
+	// Whatever was queued for the previous connection is either in the event log or stale.
+	c.drain()
+	c.push(welcome)
+	for _, e := range c.events {
+		p := newEventPacket(e)
+		c.push(p)
+		p.release()
+	}
+	return prev
+}
+
+// drain releases everything in the queue.
+// The queue lock must be held.
+func (c *Client) drain() {
+	for _, p := range c.queue {
+		queuedPackets.Add(-1)
+		p.release()
+	}
+	clear(c.queue)
+	c.queue = c.queue[:0]
+}
+
+// close stops the writer and releases everything left in the queue.
+func (c *Client) close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return
+	}
+	c.closed = true
+	close(c.quit)
+	if c.expiry != nil {
+		c.expiry.Stop()
+	}
+	c.drain()
+	c.queue = nil
+	c.events = nil
+}
+
+// newResumeToken creates the secret a client presents to resume its session on a new connection.
+func newResumeToken() string {
+	b := make([]byte, ResumeTokenSize)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
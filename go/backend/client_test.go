@@ -0,0 +1,218 @@
+package main
+
+import (
+	"crypto/rand"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lxzan/gws"
+)
+
+func withQueuePolicy(t *testing.T, policy QueuePolicy, size int) {
+	prevPolicy, prevSize := config.QueuePolicy, config.QueueSize
+	config.QueuePolicy, config.QueueSize = policy, size
+	t.Cleanup(func() { config.QueuePolicy, config.QueueSize = prevPolicy, prevSize })
+}
+
+func withMaxLag(t *testing.T, lag time.Duration) {
+	prev := config.MaxLag
+	config.MaxLag = lag
+	t.Cleanup(func() { config.MaxLag = prev })
+}
+
+// newTestConn returns the server side of a websocket connection, and the client side with the given handler.
+// The client doesn't read until its read loop is started.
+func newTestConn(t *testing.T, handler gws.Event) (*gws.Conn, *gws.Conn) {
+	t.Helper()
+	conns := make(chan *gws.Conn, 1)
+	upgrader := gws.NewUpgrader(&gws.BuiltinEventHandler{}, nil)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if conn, err := upgrader.Upgrade(w, r); err == nil {
+			conns <- conn
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	client, _, err := gws.NewClient(handler, &gws.ClientOption{
+		Addr: "ws" + strings.TrimPrefix(server.URL, "http"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = client.NetConn().Close() })
+	conn := <-conns
+	t.Cleanup(func() { _ = conn.NetConn().Close() })
+	return conn, client
+}
+
+// queueClient is a client on the connection without its writer or heartbeat, so nothing drains its queue.
+func queueClient(conn *gws.Conn) *Client {
+	return &Client{
+		ID:     1,
+		conn:   conn,
+		queue:  make([]*packet, 0, config.QueueSize),
+		notify: make(chan struct{}, 1),
+		quit:   make(chan struct{}),
+	}
+}
+
+// queueTest sends packets to a client and checks its queue.
+type queueTest struct {
+	t      *testing.T
+	client *Client
+
+	dropped, coalesced int64 // global counters when the test started
+}
+
+func newQueueTest(t *testing.T) *queueTest {
+	conn, _ := newTestConn(t, &gws.BuiltinEventHandler{})
+	q := &queueTest{
+		t:         t,
+		client:    queueClient(conn),
+		dropped:   droppedPackets.Value(),
+		coalesced: coalescedPackets.Value(),
+	}
+	t.Cleanup(q.client.close)
+	return q
+}
+
+// send sends a state or other packet to the client, returning it.
+func (q *queueTest) send(state bool) *packet {
+	p := newPacket(gws.OpcodeBinary, []byte{1}, state)
+	q.client.send(p)
+	p.release()
+	return p
+}
+
+// expect checks the queue holds exactly the given packets, and the counters match.
+func (q *queueTest) expect(queue []*packet, dropped, coalesced uint64, evicted bool) {
+	q.t.Helper()
+	q.client.mu.Lock()
+	got := append([]*packet(nil), q.client.queue...)
+	q.client.mu.Unlock()
+	if len(got) != len(queue) {
+		q.t.Fatalf("%d packets queued, expected %d", len(got), len(queue))
+	}
+	for i := range got {
+		if got[i] != queue[i] {
+			q.t.Fatalf("packet %d in the queue isn't the expected one", i)
+		}
+	}
+	stats := q.client.Stats()
+	if stats.Dropped != dropped || stats.Coalesced != coalesced {
+		q.t.Fatalf("dropped %d and coalesced %d, expected %d and %d", stats.Dropped, stats.Coalesced, dropped, coalesced)
+	}
+	if d, c := droppedPackets.Value()-q.dropped, coalescedPackets.Value()-q.coalesced; d != int64(dropped) || c != int64(coalesced) {
+		q.t.Fatalf("counted %d dropped and %d coalesced, expected %d and %d", d, c, dropped, coalesced)
+	}
+	if q.client.evicted.Load() != evicted {
+		q.t.Fatalf("evicted %v, expected %v", q.client.evicted.Load(), evicted)
+	}
+}
+
+// TestQueueDropOldest checks a full queue drops its oldest state update, and evicts the client once there's none left.
+func TestQueueDropOldest(t *testing.T) {
+	withQueuePolicy(t, DropOldest, 4)
+	q := newQueueTest(t)
+
+	s1, n1, s2, s3 := q.send(true), q.send(false), q.send(true), q.send(true)
+	q.expect([]*packet{s1, n1, s2, s3}, 0, 0, false)
+	s4 := q.send(true)
+	q.expect([]*packet{n1, s2, s3, s4}, 1, 0, false)
+	n2 := q.send(false)
+	q.expect([]*packet{n1, s3, s4, n2}, 2, 0, false)
+	n3 := q.send(false)
+	n4 := q.send(false)
+	q.expect([]*packet{n1, n2, n3, n4}, 4, 0, false)
+	q.send(true)
+	q.expect([]*packet{n1, n2, n3, n4}, 4, 0, true)
+}
+
+// TestQueueCoalesce checks a state update replaces the ones already queued,
+// and a queue that fills up anyway drops state first like DropOldest.
+func TestQueueCoalesce(t *testing.T) {
+	withQueuePolicy(t, Coalesce, 4)
+	q := newQueueTest(t)
+
+	q.send(true)
+	n1 := q.send(false)
+	s2 := q.send(true)
+	q.expect([]*packet{n1, s2}, 0, 1, false)
+	s3 := q.send(true)
+	q.expect([]*packet{n1, s3}, 0, 2, false)
+	n2, n3 := q.send(false), q.send(false)
+	q.expect([]*packet{n1, s3, n2, n3}, 0, 2, false)
+	n4 := q.send(false)
+	q.expect([]*packet{n1, n2, n3, n4}, 1, 2, false)
+	q.send(false)
+	q.expect([]*packet{n1, n2, n3, n4}, 1, 2, true)
+}
+
+// TestQueueDisconnect checks nothing is ever dropped, and the client is evicted once its queue is full.
+func TestQueueDisconnect(t *testing.T) {
+	withQueuePolicy(t, Disconnect, 2)
+	q := newQueueTest(t)
+
+	s1, s2 := q.send(true), q.send(true)
+	q.expect([]*packet{s1, s2}, 0, 0, false)
+	q.send(true)
+	q.expect([]*packet{s1, s2}, 0, 0, true)
+}
+
+// TestQueueMaxLag checks a client whose oldest queued packet is older than the max lag is evicted on the next send.
+func TestQueueMaxLag(t *testing.T) {
+	withQueuePolicy(t, Coalesce, 4)
+	withMaxLag(t, 20*time.Millisecond)
+	q := newQueueTest(t)
+
+	n1 := q.send(false)
+	q.expect([]*packet{n1}, 0, 0, false)
+	time.Sleep(2 * config.MaxLag)
+	q.send(false)
+	q.expect([]*packet{n1}, 0, 0, true)
+}
+
+// TestWriteStallEvicts checks a client whose connection stops taking writes is evicted once a write takes over the max lag.
+func TestWriteStallEvicts(t *testing.T) {
+	withQueuePolicy(t, Disconnect, 64)
+	withMaxLag(t, 100*time.Millisecond)
+	conn, _ := newTestConn(t, &gws.BuiltinEventHandler{})
+	client := queueClient(conn)
+	done := make(chan struct{})
+	go func() {
+		client.writeLoop()
+		close(done)
+	}()
+	// The writer reads the config, so it's stopped before the config is restored.
+	t.Cleanup(func() {
+		client.close()
+		<-done
+	})
+	evicted := evictedClients.Value()
+
+	// The client never reads, so the writes stall once the socket buffers are full.
+	// Nothing's sent after, so it's the writer that notices rather than the next send.
+	payload := make([]byte, 256<<10)
+	_, _ = rand.Read(payload)
+	for range config.QueueSize {
+		p := newPacket(gws.OpcodeBinary, payload, false)
+		client.send(p)
+		p.release()
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for !client.evicted.Load() {
+		if time.Now().After(deadline) {
+			t.Fatal("client wasn't evicted")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := evictedClients.Value() - evicted; n != 1 {
+		t.Fatalf("counted %d evictions, expected 1", n)
+	}
+	if client.Stats().Sent == 0 {
+		t.Fatal("no packets were written before the connection stalled")
+	}
+}
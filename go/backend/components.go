@@ -0,0 +1,56 @@
+package main
+
+// Transform places an entity in the world.
+type Transform struct {
+	Position [3]float32
+	Rotation [4]float32 // quaternion x, y, z, w
+	Scale    [3]float32
+}
+
+// newTransform is a transform at the given position with no rotation and unit scale.
+func newTransform(position [3]float32) Transform {
+	return Transform{
+		Position: position,
+		Rotation: [4]float32{0, 0, 0, 1},
+		Scale:    [3]float32{1, 1, 1},
+	}
+}
+
+// Velocity moves an entity's transform each tick, in units per second.
+type Velocity [3]float32
+
+// ModelID names a model the frontend loads, see its resource descriptors.
+type ModelID uint8
+
+const (
+	ModelCube ModelID = iota
+	ModelMonke
+)
+
+// Model is the mesh an entity is rendered with.
+type Model struct {
+	ID ModelID
+}
+
+// Material is the surface an entity's model is shaded with, matching the frontend model's PBR parameters.
+type Material struct {
+	Metallic  float32
+	Roughness float32
+	AO        float32
+}
+
+// Owner is the client an entity belongs to, such as its player.
+type Owner struct {
+	Client CID
+}
+
+// Controller steers an entity by its owner's input commands, applying one per tick.
+// Commands are buffered for a few ticks first so they're applied at an even pace despite network jitter.
+type Controller struct {
+	Input Input // the command applied last tick, with no buttons held while the buffer is empty
+	Yaw   float32
+	Pitch float32
+
+	inputs    []Input // buffered commands, by sequence number
+	buffering bool    // waiting for the buffer to fill back up before applying commands again
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"time"
+)
+
+// QueuePolicy decides what happens when a client's send queue is full.
+type QueuePolicy int
+
+const (
+	// DropOldest drops the oldest queued state update to make room.
+	DropOldest QueuePolicy = iota
+	// Coalesce keeps only the latest state update in the queue, and drops the oldest when full.
+	Coalesce
+	// Disconnect never drops anything, and disconnects the client once the queue is full.
+	Disconnect
+)
+
+var queuePolicyNames = map[QueuePolicy]string{
+	DropOldest: "drop-oldest",
+	Coalesce:   "coalesce",
+	Disconnect: "disconnect",
+}
+
+func (p QueuePolicy) String() string {
+	return queuePolicyNames[p]
+}
+
+func (p *QueuePolicy) Set(s string) error {
+	for policy, name := range queuePolicyNames {
+		if name == s {
+			*p = policy
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown queue policy %q", s)
+}
+
+// Config holds the server's tunables.
+// The defaults below can be overridden with command line flags.
+type Config struct {
+	QueuePolicy QueuePolicy
+	QueueSize   int
+	MaxLag      time.Duration
+	ResumeGrace time.Duration
+
+	HeartbeatInterval   time.Duration
+	MaxMissedHeartbeats int
+
+	TickRate int
+
+	MaxDecodeErrors int
+
+	JSONWire bool
+}
+
+var config = Config{
+	QueuePolicy: Coalesce,
+	QueueSize:   64,
+	MaxLag:      5 * time.Second,
+	ResumeGrace: 30 * time.Second,
+
+	HeartbeatInterval:   2 * time.Second,
+	MaxMissedHeartbeats: 3,
+
+	TickRate: 30,
+
+	MaxDecodeErrors: 10,
+}
+
+// RegisterFlags binds the config to command line flags.
+func (c *Config) RegisterFlags(fs *flag.FlagSet) {
+	fs.Var(&c.QueuePolicy, "queue-policy", "full send queue policy: drop-oldest, coalesce, or disconnect")
+	fs.IntVar(&c.QueueSize, "queue-size", c.QueueSize, "maximum number of messages queued per client")
+	fs.DurationVar(&c.MaxLag, "max-lag", c.MaxLag, "disconnect clients whose oldest queued message is older than this")
+	fs.DurationVar(&c.ResumeGrace, "resume-grace", c.ResumeGrace, "how long a dropped client's slot is held for it to resume")
+	fs.DurationVar(&c.HeartbeatInterval, "heartbeat", c.HeartbeatInterval, "interval between pings sent to each client")
+	fs.IntVar(&c.MaxMissedHeartbeats, "max-missed-heartbeats", c.MaxMissedHeartbeats, "disconnect clients that leave more than this many pings in a row unanswered")
+	fs.IntVar(&c.TickRate, "tick-rate", c.TickRate, "game ticks per second, usually 20, 30, or 60")
+	fs.BoolVar(&c.JSONWire, "json-wire", c.JSONWire, "send messages as JSON text frames and accept them from clients, to read traffic in the browser's devtools; needs a build with -tags jsonwire")
+	fs.IntVar(&c.MaxDecodeErrors, "max-decode-errors", c.MaxDecodeErrors, "disconnect clients after this many messages that can't be decoded")
+}
+
+// Validate checks the config for values the server can't run with.
+func (c *Config) Validate() error {
+	if c.QueueSize < 1 {
+		return fmt.Errorf("queue size must be at least 1, got %d", c.QueueSize)
+	}
+	if c.MaxLag <= 0 {
+		return fmt.Errorf("max lag must be positive, got %v", c.MaxLag)
+	}
+	if c.ResumeGrace < 0 {
+		return fmt.Errorf("resume grace can't be negative, got %v", c.ResumeGrace)
+	}
+	if c.HeartbeatInterval <= 0 {
+		return fmt.Errorf("heartbeat interval must be positive, got %v", c.HeartbeatInterval)
+	}
+	if c.MaxMissedHeartbeats < 0 {
+		return fmt.Errorf("max missed heartbeats can't be negative, got %d", c.MaxMissedHeartbeats)
+	}
+	if c.TickRate < 1 || c.TickRate > int(time.Second) {
+		return fmt.Errorf("tick rate must be from 1 to %d, got %d", int(time.Second), c.TickRate)
+	}
+	if c.MaxDecodeErrors < 0 {
+		return fmt.Errorf("max decode errors can't be negative, got %d", c.MaxDecodeErrors)
+	}
+	return nil
+}
+
+// HeartbeatTimeout is how long a connection can go without answering a ping.
+// That's an interval for the next ping to go out, then one for each ping it may leave unanswered and the last one.
+func (c *Config) HeartbeatTimeout() time.Duration {
+	return c.HeartbeatInterval * time.Duration(c.MaxMissedHeartbeats+2)
+}
+
+// TickInterval is the fixed time step of a game tick.
+func (c *Config) TickInterval() time.Duration {
+	return time.Second / time.Duration(c.TickRate)
+}
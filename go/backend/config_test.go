@@ -0,0 +1,39 @@
+package main
+
+import (
+	"flag"
+	"strings"
+	"testing"
+)
+
+// TestConfigValidate checks the flags the server can't run with are rejected, and the defaults aren't.
+func TestConfigValidate(t *testing.T) {
+	for _, c := range []struct {
+		args []string
+		ok   bool
+	}{
+		{nil, true},
+		{[]string{"-queue-size", "1", "-max-missed-heartbeats", "0", "-resume-grace", "0", "-max-decode-errors", "0"}, true},
+		{[]string{"-tick-rate", "1"}, true},
+		{[]string{"-queue-size", "0"}, false},
+		{[]string{"-queue-size", "-1"}, false},
+		{[]string{"-max-lag", "0"}, false},
+		{[]string{"-max-lag", "-1s"}, false},
+		{[]string{"-resume-grace", "-1s"}, false},
+		{[]string{"-heartbeat", "0"}, false},
+		{[]string{"-max-missed-heartbeats", "-1"}, false},
+		{[]string{"-tick-rate", "0"}, false},
+		{[]string{"-tick-rate", "2000000000"}, false},
+		{[]string{"-max-decode-errors", "-1"}, false},
+	} {
+		cfg := config
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		cfg.RegisterFlags(fs)
+		if err := fs.Parse(c.args); err != nil {
+			t.Fatalf("%v: %v", c.args, err)
+		}
+		if err := cfg.Validate(); (err == nil) != c.ok {
+			t.Errorf("%s: got %v, expected ok %v", strings.Join(c.args, " "), err, c.ok)
+		}
+	}
+}
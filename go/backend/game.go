@@ -1,51 +1,157 @@
 package main
 
 import (
+	"expvar"
 	"log"
+	"sync/atomic"
 	"time"
 
 	"github.com/lxzan/gws"
+
+	"webgl-multiplayer/movement"
+	"webgl-multiplayer/protocol"
 )
 
 const (
-	EventBufferSize = 2048
-	UpdateInterval  = time.Second
+	EventBufferSize    = 2048
+	MaxCatchUpTicks    = 5 // ticks run back to back after a stall, the rest are skipped
+	ScoreboardInterval = 2 * time.Second
 )
 
+var tickOverruns = expvar.NewInt("tick_overruns")
+
 type Game struct {
 	hub     *Hub
 	inbound chan *InboundMessage
+	clients map[CID]*Client
+	world   *World
+	players map[CID]Entity // each client's player
+	quit    chan struct{}
+	done    chan struct{}
+
+	snapshots [SnapshotBufferSize]*snapshot // by tick modulo the buffer size
+	acks      map[CID]uint32                // latest snapshot tick each client acknowledged
+
+	// dt is the fixed time step every tick simulates.
+	dt time.Duration
+	// tickNum is the number of the next tick to run, tick n covers origin + n*dt to origin + (n+1)*dt.
+	tickNum uint32
+	// origin is the time of tick 0 since the server started, moved forward when ticks are skipped.
+	// It's read by clock sync requests on the connection goroutines.
+	origin atomic.Int64
+
+	lastScoreboard uint32
+
+	// eventSeq is the sequence number of the last event sent, events are numbered across the whole room.
+	eventSeq uint32
 }
 
 func NewGame(hub *Hub) *Game {
-	return &Game{
+	g := &Game{
 		hub:     hub,
 		inbound: make(chan *InboundMessage, EventBufferSize),
+		clients: make(map[CID]*Client),
+		world:   newWorld(),
+		players: make(map[CID]Entity),
+		acks:    make(map[CID]uint32),
+		quit:    make(chan struct{}),
+		done:    make(chan struct{}),
+		dt:      config.TickInterval(),
 	}
+	g.origin.Store(int64(time.Since(epoch)))
+	return g
 }
 
 func (g *Game) Run() {
-	ticker := time.NewTicker(UpdateInterval)
-	quit := make(chan struct{})
+	ticker := time.NewTicker(g.dt)
 	go func() {
+		defer close(g.done)
+		defer ticker.Stop()
 		for {
 			select {
 			case <-ticker.C:
-				g.tick()
-			case <-quit:
-				ticker.Stop()
+				g.advance()
+			case <-g.quit:
 				return
 			}
 		}
 	}()
 }
 
+// Origin is the time of tick 0 since the server started.
+func (g *Game) Origin() time.Duration {
+	return time.Duration(g.origin.Load())
+}
+
+// advance runs every tick whose time step has fully elapsed.
+// The ticker only wakes the loop, so late or dropped wake-ups are made up here,
+// but at most MaxCatchUpTicks at once so a long stall doesn't snowball into ticks that never catch up.
+func (g *Game) advance() {
+	due := int64((time.Since(epoch)-g.Origin())/g.dt) - int64(g.tickNum)
+	if due > MaxCatchUpTicks {
+		skipped := due - MaxCatchUpTicks
+		g.origin.Add(skipped * int64(g.dt))
+		due = MaxCatchUpTicks
+		if Debug {
+			log.Println("game fell behind, skipped ", skipped, " ticks")
+		}
+	}
+	for range due {
+		start := time.Now()
+		g.tick()
+		if elapsed := time.Since(start); elapsed > g.dt {
+			tickOverruns.Add(1)
+			if Debug {
+				log.Println("tick ", g.tickNum-1, " overran its budget: ", elapsed)
+			}
+		}
+	}
+}
+
+// Stop ends the tick loop and waits for the current tick to finish.
+func (g *Game) Stop() {
+	close(g.quit)
+	<-g.done
+}
+
+// syncClock answers a clock sync request right away on the connection it came on, rather than waiting for the next tick.
+// Received is the server time the request arrived at.
+// The answer skips the hub and the client's send queue, and is stamped once it's next in the connection's write queue,
+// so time spent queued on the server isn't taken for network delay.
+func (g *Game) syncClock(conn *gws.Conn, request *protocol.TimeRequest, received time.Duration) {
+	origin := g.Origin()
+	conn.Async(func() {
+		_ = conn.WriteMessage(wireOpcode(), encodeTime(g.hub.tick.Load(), request.Sent, received, origin, g.dt))
+	})
+}
+
+// Receive queues a message from a client for the next tick, unless the game is stopped.
+func (g *Game) Receive(message *InboundMessage) {
+	select {
+	case g.inbound <- message:
+	case <-g.done:
+	}
+}
+
+// tick simulates one fixed time step.
 func (g *Game) tick() {
+	g.hub.tick.Store(g.tickNum)
+
+presence:
+	for {
+		select {
+		case p := <-g.hub.presence:
+			g.onPresence(p)
+		default:
+			break presence
+		}
+	}
+
 inbound:
 	for {
 		select {
 		case message := <-g.inbound:
-			log.Println("message ", message.Payload)
+			g.onMessage(message)
 		default:
 			{
 				break inbound
@@ -53,8 +159,102 @@ inbound:
 		}
 	}
 
-	g.hub.broadcast <- &OutboundMessage{
-		Opcode:  gws.OpcodeBinary,
-		Payload: []uint8{1, 2, 3},
+	g.world.Step(g.dt.Seconds())
+
+	g.sendSnapshots()
+
+	if time.Duration(g.tickNum-g.lastScoreboard)*g.dt >= ScoreboardInterval {
+		g.lastScoreboard = g.tickNum
+		// Not a state update, those are a single latest-wins stream of snapshots that would coalesce it away.
+		g.hub.broadcast <- &OutboundMessage{
+			Opcode:  wireOpcode(),
+			Payload: encodeLatencies(g.tickNum, g.clients),
+		}
+	}
+
+	g.tickNum++
+}
+
+// onMessage applies a message from a client to the game.
+// Messages from clients that already left are dropped.
+func (g *Game) onMessage(message *InboundMessage) {
+	// Presence is handled first, a message still queued when its client left would otherwise bring back its state.
+	if g.clients[message.Client.ID] != message.Client {
+		return
+	}
+	switch m := message.Message.(type) {
+	case *protocol.Input:
+		if c := g.world.Controllers.Get(g.players[message.Client.ID]); c != nil {
+			c.queueInput(Input{
+				Seq:   m.Seq,
+				Input: movement.Input{Buttons: m.Buttons, Yaw: m.Yaw, Pitch: m.Pitch},
+			})
+		}
+	case *protocol.Ack:
+		g.onAck(message.Client.ID, m.Tick)
+	case *protocol.ChatRequest:
+		if text := sanitize(m.Text, MaxChatSize); text != "" {
+			g.broadcastEvent(&protocol.Chat{Client: uint16(message.Client.ID), Text: text})
+		}
+	default:
+		if Debug {
+			log.Println("unexpected message from ", message.Client.ID, ": ", m)
+		}
+	}
+}
+
+// onPresence spawns or removes a client's player, and tells everyone else about the client joining or leaving.
+// New clients get the full roster, including themselves.
+func (g *Game) onPresence(p *Presence) {
+	id := p.Client.ID
+	if p.Joined {
+		g.clients[id] = p.Client
+		if e, err := spawnPlayer(g.world, id); err == nil {
+			g.players[id] = e
+		} else {
+			log.Println("Error spawning player: ", err)
+		}
+		g.sendEvent([]CID{id}, newRoster(g.clients))
+		g.sendEvent(g.others(id), &protocol.Joined{Client: uint16(id), Name: p.Client.Name})
+	} else {
+		delete(g.clients, id)
+		g.world.Destroy(g.players[id])
+		delete(g.players, id)
+		delete(g.acks, id)
+		g.broadcastEvent(&protocol.Left{Client: uint16(id), Reason: uint8(p.Reason)})
+	}
+	if Debug {
+		log.Println("presence ", id, p.Client.Name, ", joined: ", p.Joined, ", reason: ", p.Reason)
+	}
+}
+
+// sendEvent sends a reliable event to the given clients.
+func (g *Game) sendEvent(clients []CID, m protocol.Message) {
+	g.eventSeq++
+	g.hub.events <- &EventMessage{
+		Seq:     g.eventSeq,
+		Payload: encodeEvent(g.tickNum, g.eventSeq, m),
+		Clients: clients,
+	}
+}
+
+// broadcastEvent sends a reliable event to every client.
+func (g *Game) broadcastEvent(m protocol.Message) {
+	g.eventSeq++
+	g.hub.events <- &EventMessage{
+		Seq:       g.eventSeq,
+		Payload:   encodeEvent(g.tickNum, g.eventSeq, m),
+		Broadcast: true,
+	}
+}
+
+// others returns the IDs of every client in the game except the given one.
+func (g *Game) others(except CID) []CID {
+	ids := make([]CID, 0, len(g.clients))
+	for id := range g.clients {
+		if id != except {
+			ids = append(ids, id)
+		}
 	}
+	return ids
 }
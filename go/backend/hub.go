@@ -1,55 +1,162 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/lxzan/gws"
+
+	"webgl-multiplayer/protocol"
 )
 
 const (
-	MaxClients = 256
+	MaxClients         = 256
+	MaxWaiting         = 32 // connections queued while the hub is full, 0 rejects them outright
+	OutboundBufferSize = 256
+	CloseWait          = 2 * time.Second
+	MaxMessageSize     = 1024 // largest message read from a client, well above the largest the protocol has
 )
 
-type CID uint16
+// WebSocket close codes sent by the server.
+const (
+	CloseGoingAway       uint16 = 1001
+	ClosePolicyViolation uint16 = 1008
+	CloseTryAgainLater   uint16 = 1013
+)
 
-type Client struct {
-	ID   CID
-	Conn *gws.Conn
-}
+// Machine readable close reasons sent by the server.
+const (
+	ReasonServerFull      = "server_full"
+	ReasonSlowClient      = "slow_client"
+	ReasonReplaced        = "session_replaced"
+	ReasonInvalidMessages = "invalid_messages"
+)
+
+// The hub multiplexes two channels on every connection.
+// State messages are latest-wins world updates, which may be dropped or coalesced for slow clients.
+// Every state message replaces any other, so only snapshots are sent as state.
+// Events are reliable and ordered, kept by each recipient until it acknowledges them and sent again after it resumes.
+// Other messages are neither, they're sent once and lost if the connection drops.
 
 // OutboundMessage is a message that is broadcasted to all clients.
 type OutboundMessage struct {
 	Opcode  gws.Opcode
 	Payload []byte
+	State   bool
+}
+
+// UnicastMessage is a message that is sent to a single client.
+type UnicastMessage struct {
+	Client  CID
+	Opcode  gws.Opcode
+	Payload []byte
+	State   bool
 }
 
-// InboundMessage is a message that is received from a client.
+// MulticastMessage is a message that is sent to a set of clients.
+// Client IDs that aren't registered are skipped.
+type MulticastMessage struct {
+	Clients []CID
+	Opcode  gws.Opcode
+	Payload []byte
+	State   bool
+}
+
+// EventMessage is a reliable event, sent to every client or to a set of them.
+// Events must be sent in sequence, they all go through the same channel so the hub keeps them in order.
+// Client IDs that aren't registered are skipped.
+type EventMessage struct {
+	Seq       uint32
+	Payload   []byte
+	Broadcast bool
+	Clients   []CID // ignored when broadcasting
+}
+
+// InboundMessage is a message that is received from a client, decoded on the connection's goroutine.
 type InboundMessage struct {
 	Client  *Client
-	Payload []byte
+	Message protocol.Message
 }
 
-// Hub maintains a pool of clients and broadcasts messages to connected clients.
+// Hub maintains a pool of clients and sends messages to connected clients,
+// either to all of them (broadcast), one (unicast), or a subset (multicast).
 // Clients are registered and unregistered automatically.
+// Clients whose connection dropped are suspended for a grace period, holding their ID until they resume.
+// Connections waiting for a free ID are pinged by the hub, so they don't time out while they wait.
+// The hub's maps are only touched by its run loop, connection handlers find their client in the session storage.
 type Hub struct {
-	Clients     map[CID]*Client
+	clients     map[CID]*Client
 	cidPool     []CID
 	connections map[*gws.Conn]CID
+	sessions    map[string]*Client // by resume token
+	waiting     []*gws.Conn
 	broadcast   chan *OutboundMessage
-	register    chan *gws.Conn
-	unregister  chan *gws.Conn
+	unicast     chan *UnicastMessage
+	multicast   chan *MulticastMessage
+	events      chan *EventMessage
+	register    chan *registration
+	unregister  chan *disconnect
+	expire      chan *Client
+	presence    chan *Presence
+	release     func()
+	quit        chan struct{}
+	done        chan struct{}
+
+	// tick is the game's current tick, stamped on messages the hub sends on its own.
+	tick atomic.Uint32
+}
+
+// LeaveReason is why a client left the hub.
+type LeaveReason uint8
+
+const (
+	LeaveQuit    LeaveReason = iota // the client closed the connection
+	LeaveTimeout                    // the connection dropped and the client didn't resume in time
+	LeaveKicked                     // the server disconnected the client
+)
+
+// Presence is a client joining or leaving the hub, reported to the game.
+type Presence struct {
+	Client *Client
+	Joined bool
+	Reason LeaveReason
+}
+
+// registration is a new connection, done is closed once the hub has handled it.
+type registration struct {
+	conn *gws.Conn
+	done chan struct{}
+}
+
+// disconnect is a closed connection along with the error it closed with.
+type disconnect struct {
+	conn *gws.Conn
+	err  error
 }
 
 // NewHub creates an instance of Hub with a client pool of capacity {MaxClients}.
-func NewHub() *Hub {
+// Release is called every time a connection's slot is freed, either on disconnect or once a suspended client expires.
+func NewHub(release func()) *Hub {
 	hub := &Hub{
-		Clients:     make(map[CID]*Client),
+		clients:     make(map[CID]*Client),
 		connections: make(map[*gws.Conn]CID),
-		broadcast:   make(chan *OutboundMessage),
+		sessions:    make(map[string]*Client),
+		broadcast:   make(chan *OutboundMessage, OutboundBufferSize),
+		unicast:     make(chan *UnicastMessage, OutboundBufferSize),
+		multicast:   make(chan *MulticastMessage, OutboundBufferSize),
+		events:      make(chan *EventMessage, OutboundBufferSize),
 		cidPool:     make([]CID, MaxClients),
-		register:    make(chan *gws.Conn),
-		unregister:  make(chan *gws.Conn),
+		register:    make(chan *registration),
+		unregister:  make(chan *disconnect),
+		expire:      make(chan *Client),
+		presence:    make(chan *Presence, EventBufferSize),
+		release:     release,
+		quit:        make(chan struct{}),
+		done:        make(chan struct{}),
 	}
 	for i := 0; i < MaxClients; i++ {
 		hub.cidPool[i] = CID(i)
@@ -67,51 +174,277 @@ func NewHub() *Hub {
 	return hub
 }
 
-// Run selects broadcast messages, incoming connections, and disconnection messages.
+// Run selects outbound messages, incoming connections, and disconnection messages.
 // Connections are registered automaticaly when opened by the client.
 // Diconnect messages are sent when the connection is closed automatically.
 func (h *Hub) run() {
+	defer close(h.done)
+	heartbeat := time.NewTicker(config.HeartbeatInterval)
+	defer heartbeat.Stop()
 	for {
 		select {
-		case conn := <-h.register: // register a new client
-			if len(h.cidPool) == 0 {
-				conn.NetConn().Close()
-				return
+		case <-heartbeat.C: // ping waiting connections, admitted clients ping their own
+			for _, conn := range h.waiting {
+				conn.WriteAsync(gws.OpcodePing, nil, nil)
 			}
-			id := h.cidPool[0]
-			h.cidPool = h.cidPool[1:]
-			h.connections[conn] = id
-			h.Clients[id] = &Client{
-				ID:   id,
-				Conn: conn,
+		case <-h.quit:
+			h.closeAll(CloseGoingAway, "server shutting down")
+			return
+		case r := <-h.register: // register a new client
+			conn := r.conn
+			token, _ := conn.Session().Load(sessionResume)
+			if client, ok := h.sessions[token.(string)]; ok && !client.evicted.Load() {
+				h.resume(client, conn)
+			} else if len(h.cidPool) > 0 {
+				h.admit(conn)
+			} else if len(h.waiting) < MaxWaiting {
+				// Hold on to the connection until a client ID frees up.
+				conn.Session().Store(sessionWaiting, true)
+				h.waiting = append(h.waiting, conn)
+				if Debug {
+					fmt.Println("hub full, client waiting, queue length: ", len(h.waiting))
+				}
+			} else {
+				h.reject(conn, CloseTryAgainLater, ReasonServerFull)
 			}
-			if Debug {
-				fmt.Println("client registered, id: ", id)
-				fmt.Println("curent connsections:")
-				for _, c := range h.connections {
-					fmt.Println("    id: ", c)
+			close(r.done)
+		case d := <-h.unregister: // unregister a client
+			if id, ok := h.connections[d.conn]; ok {
+				client := h.clients[id]
+				delete(h.connections, d.conn)
+				if reason := leaveReason(client, d.err); reason == LeaveTimeout {
+					h.suspend(client)
+				} else {
+					h.remove(client, reason)
 				}
-				fmt.Println("curent clients:")
-				for _, c := range h.Clients {
-					fmt.Println("    id: ", c.ID, ", client address: ", c)
+			} else {
+				for i, c := range h.waiting {
+					if c == d.conn {
+						h.waiting = append(h.waiting[:i], h.waiting[i+1:]...)
+						break
+					}
 				}
+				h.release()
 			}
-		case conn := <-h.unregister: // unregister a client
-			if id, ok := h.connections[conn]; ok {
-				delete(h.Clients, id)
-				delete(h.connections, conn)
-				h.cidPool = append(h.cidPool, id)
-				if Debug {
-					fmt.Println("client unregistered, id: ", id)
-				}
+		case client := <-h.expire: // a suspended client's grace period ended
+			if h.sessions[client.token] == client && client.suspended {
+				h.remove(client, LeaveTimeout)
 			}
 		case message := <-h.broadcast: // broadcast to all clients
 			// This does premessage deflate just once rather than for every client.
-			b := gws.NewBroadcaster(message.Opcode, message.Payload)
-			defer b.Close()
-			for _, client := range h.Clients {
-				b.Broadcast(client.Conn)
+			p := newPacket(message.Opcode, message.Payload, message.State)
+			for _, client := range h.clients {
+				client.send(p)
+			}
+			p.release()
+		case message := <-h.unicast: // send to a single client
+			if client, ok := h.clients[message.Client]; ok {
+				p := newPacket(message.Opcode, message.Payload, message.State)
+				client.send(p)
+				p.release()
+			}
+		case message := <-h.multicast: // send to a subset of clients
+			// Same as a broadcast, the payload is only compressed once for all recipients.
+			p := newPacket(message.Opcode, message.Payload, message.State)
+			for _, id := range message.Clients {
+				if client, ok := h.clients[id]; ok {
+					client.send(p)
+				}
+			}
+			p.release()
+		case message := <-h.events: // send an event to every client or a subset
+			e := &event{seq: message.Seq, payload: message.Payload}
+			p := newEventPacket(e)
+			if message.Broadcast {
+				for _, client := range h.clients {
+					client.send(p)
+				}
+			} else {
+				for _, id := range message.Clients {
+					if client, ok := h.clients[id]; ok {
+						client.send(p)
+					}
+				}
 			}
+			p.release()
 		}
 	}
 }
+
+// admit assigns a client ID from the pool to the connection.
+// There must be at least one ID left in the pool.
+func (h *Hub) admit(conn *gws.Conn) {
+	id := h.cidPool[0]
+	h.cidPool = h.cidPool[1:]
+	h.connections[conn] = id
+	name, _ := conn.Session().Load(sessionName)
+	client := newClient(id, name.(string), conn)
+	h.clients[id] = client
+	h.sessions[client.token] = client
+	conn.Session().Store(sessionClient, client)
+	// Only once the client is stored, so messages the connection sends meanwhile are never taken for an unregistered one's.
+	conn.Session().Delete(sessionWaiting)
+	h.welcome(client, false)
+	h.notify(&Presence{Client: client, Joined: true})
+	if Debug {
+		fmt.Println("client registered, id: ", id)
+		fmt.Println("curent connsections:")
+		for _, c := range h.connections {
+			fmt.Println("    id: ", c)
+		}
+		fmt.Println("curent clients:")
+		for _, c := range h.clients {
+			fmt.Println("    id: ", c.ID, ", name: ", c.Name)
+		}
+	}
+}
+
+// remove frees the client's ID, admitting the next waiting connection if there is one.
+func (h *Hub) remove(client *Client, reason LeaveReason) {
+	client.close()
+	delete(h.clients, client.ID)
+	delete(h.sessions, client.token)
+	h.cidPool = append(h.cidPool, client.ID)
+	h.release()
+	h.notify(&Presence{Client: client, Reason: reason})
+	if Debug {
+		fmt.Println("client unregistered, id: ", client.ID)
+	}
+	if len(h.waiting) > 0 {
+		next := h.waiting[0]
+		h.waiting = h.waiting[1:]
+		h.admit(next)
+	}
+}
+
+// suspend holds on to a client whose connection dropped, until it resumes or the grace period ends.
+func (h *Hub) suspend(client *Client) {
+	client.suspend()
+	client.suspended = true
+	client.expiry = time.AfterFunc(config.ResumeGrace, func() {
+		select {
+		case h.expire <- client:
+		case <-h.done:
+		}
+	})
+	if Debug {
+		fmt.Println("client suspended, id: ", client.ID)
+	}
+}
+
+// resume moves a client onto its new connection, keeping its ID.
+// The client may not have been suspended yet if it reconnected before the server noticed the drop,
+// in which case the old connection is closed.
+func (h *Hub) resume(client *Client, conn *gws.Conn) {
+	h.connections[conn] = client.ID
+	conn.Session().Store(sessionClient, client)
+	p := newPacket(wireOpcode(), encodeWelcome(h.tick.Load(), client, true), false)
+	prev := client.resume(conn, p)
+	p.release()
+	if client.suspended {
+		client.suspended = false
+		// The new connection took a slot of its own, which the resumed client already holds.
+		h.release()
+	} else {
+		// The old connection releases its slot once it unregisters.
+		delete(h.connections, prev)
+		closeConn(prev, ClosePolicyViolation, ReasonReplaced)
+	}
+	if Debug {
+		fmt.Println("client resumed, id: ", client.ID)
+	}
+}
+
+// welcome sends the client its ID and resume token.
+func (h *Hub) welcome(client *Client, resumed bool) {
+	p := newPacket(wireOpcode(), encodeWelcome(h.tick.Load(), client, resumed), false)
+	client.send(p)
+	p.release()
+}
+
+// notify reports a presence change to the game.
+func (h *Hub) notify(p *Presence) {
+	select {
+	case h.presence <- p:
+	case <-h.quit:
+	}
+}
+
+// leaveReason decides why a client's connection closed with the given error.
+// Only clients that timed out are suspended, those that left on purpose or were kicked can't resume.
+func leaveReason(client *Client, err error) LeaveReason {
+	if client.evicted.Load() {
+		return LeaveKicked
+	}
+	var closeErr *gws.CloseError
+	if errors.As(err, &closeErr) && (closeErr.Code == 1000 || closeErr.Code == CloseGoingAway) {
+		return LeaveQuit
+	}
+	return LeaveTimeout
+}
+
+// reject closes a connection that isn't registered with the hub.
+// The close frame is written off the run loop, so a stalled connection can't hold up the room.
+func (h *Hub) reject(conn *gws.Conn, code uint16, reason string) {
+	_ = conn.SetWriteDeadline(time.Now().Add(CloseWait))
+	go func() {
+		_ = conn.WriteClose(code, []byte(reason))
+		_ = conn.NetConn().Close()
+	}()
+	if Debug {
+		fmt.Println("client rejected, reason: ", reason)
+	}
+}
+
+// Close sends a close frame to every connected client, then stops the hub's run loop and waits for it to exit.
+func (h *Hub) Close() {
+	close(h.quit)
+	<-h.done
+}
+
+// Register adds the connection to the hub, unless the hub is closed.
+// Once it returns, the connection's client is in its session storage if it was admitted.
+func (h *Hub) Register(conn *gws.Conn) {
+	r := &registration{conn: conn, done: make(chan struct{})}
+	select {
+	case h.register <- r:
+	case <-h.done:
+		return
+	}
+	select {
+	case <-r.done:
+	case <-h.done:
+	}
+}
+
+// Unregister removes the connection from the hub, unless the hub is closed.
+// The error the connection closed with decides whether the client can resume.
+func (h *Hub) Unregister(conn *gws.Conn, err error) {
+	select {
+	case h.unregister <- &disconnect{conn: conn, err: err}:
+	case <-h.done:
+	}
+}
+
+// closeAll sends a close frame to every connection.
+// The frames are written concurrently under one shared CloseWait deadline, so a stalled client can't hold up the others.
+func (h *Hub) closeAll(code uint16, reason string) {
+	for _, client := range h.clients {
+		client.close()
+	}
+	conns := append(make([]*gws.Conn, 0, len(h.connections)+len(h.waiting)), h.waiting...)
+	for conn := range h.connections {
+		conns = append(conns, conn)
+	}
+	deadline := time.Now().Add(CloseWait)
+	var wg sync.WaitGroup
+	for _, conn := range conns {
+		_ = conn.SetWriteDeadline(deadline)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_ = conn.WriteClose(code, []byte(reason))
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,740 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lxzan/gws"
+
+	"webgl-multiplayer/protocol"
+)
+
+// testClient is a websocket client that hands every received message to a channel.
+type testClient struct {
+	gws.BuiltinEventHandler
+	messages chan []byte
+	closed   chan struct{}
+	err      error // the connection closed with, set before closed is closed
+	ackAll   bool  // acknowledge every event as it arrives, like the wasm client
+}
+
+func (c *testClient) OnMessage(conn *gws.Conn, message *gws.Message) {
+	defer message.Close()
+	if c.ackAll {
+		if _, m, err := protocol.Decode(message.Bytes()); err == nil {
+			if event, ok := m.(*protocol.Event); ok {
+				conn.WriteAsync(gws.OpcodeBinary, protocol.EncodeClient(&protocol.EventAck{Seq: event.Seq}), nil)
+			}
+		}
+	}
+	select {
+	case c.messages <- append([]byte(nil), message.Bytes()...):
+	default:
+	}
+}
+
+// OnPing echoes the payload like browsers do, the server measures round trips with it.
+func (c *testClient) OnPing(conn *gws.Conn, payload []byte) {
+	_ = conn.WritePong(payload)
+}
+
+func (c *testClient) OnClose(conn *gws.Conn, err error) {
+	c.err = err
+	close(c.closed)
+}
+
+func newTestServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(serveWS(newUpgrader()))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func dial(t *testing.T, server *httptest.Server, query string) (*gws.Conn, *testClient) {
+	t.Helper()
+	return dialClient(t, server, query, &testClient{})
+}
+
+// dialClient connects the given client, set up before its read loop starts.
+func dialClient(t *testing.T, server *httptest.Server, query string, client *testClient) (*gws.Conn, *testClient) {
+	t.Helper()
+	client.messages = make(chan []byte, 64)
+	client.closed = make(chan struct{})
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws" + query
+	conn, _, err := gws.NewClient(client, &gws.ClientOption{
+		Addr:              url,
+		PermessageDeflate: gws.PermessageDeflate{Enabled: true},
+		RequestHeader:     http.Header{"Sec-WebSocket-Protocol": {protocol.Subprotocol(protocol.Version)}},
+	})
+	if err != nil {
+		t.Fatalf("dial %s: %v", url, err)
+	}
+	go conn.ReadLoop()
+	return conn, client
+}
+
+// waitFor reads messages until one of the given type arrives, returning its body.
+func (c *testClient) waitFor(t *testing.T, msg byte) []byte {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case b := <-c.messages:
+			if len(b) >= protocol.HeaderSize && b[0] == msg {
+				return b[protocol.HeaderSize:]
+			}
+		case <-timeout:
+			t.Fatalf("timed out waiting for message %d", msg)
+		}
+	}
+}
+
+// waitForEvent reads messages until an event arrives.
+func (c *testClient) waitForEvent(t *testing.T) *protocol.Event {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case b := <-c.messages:
+			if _, m, err := protocol.Decode(b); err == nil {
+				if event, ok := m.(*protocol.Event); ok {
+					return event
+				}
+			}
+		case <-timeout:
+			t.Fatal("timed out waiting for an event")
+		}
+	}
+}
+
+// waitForRooms waits until every room has been torn down.
+func waitForRooms(t *testing.T) {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		rooms.mu.Lock()
+		n := len(rooms.rooms)
+		rooms.mu.Unlock()
+		if n == 0 {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("rooms were not torn down after every client left")
+}
+
+func withResumeGrace(t *testing.T, grace time.Duration) {
+	prev := config.ResumeGrace
+	config.ResumeGrace = grace
+	t.Cleanup(func() { config.ResumeGrace = prev })
+}
+
+// Connects and disconnects hundreds of clients across a few rooms while they're all sending messages.
+// Run with -race, it's meant to catch handlers touching hub state.
+func TestConnectDisconnectUnderLoad(t *testing.T) {
+	withResumeGrace(t, 50*time.Millisecond)
+	// Every client's chat goes to the whole room, more events at once than the default queue holds.
+	withQueueSize(t, 1024)
+	server := newTestServer(t)
+
+	const clients = 400
+	var wg sync.WaitGroup
+	for i := 0; i < clients; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// Events are acknowledged so every client stays connected, and its messages reach the game.
+			conn, client := dialClient(t, server, fmt.Sprintf("?room=load-%d&name=bot%d", i%4, i), &testClient{ackAll: true})
+			for j := 0; j < 20; j++ {
+				var m protocol.Message
+				switch j % 10 {
+				case 0:
+					m = &protocol.ChatRequest{Text: fmt.Sprintf("hi from bot%d", i)}
+				case 5:
+					m = &protocol.Ack{Tick: uint32(j)}
+				default:
+					m = &protocol.Input{Seq: uint32(j), Buttons: uint8(j), Yaw: float32(j)}
+				}
+				if err := conn.WriteMessage(gws.OpcodeBinary, protocol.EncodeClient(m)); err != nil {
+					break
+				}
+			}
+			if i%2 == 0 {
+				_ = conn.WriteClose(1000, nil)
+			} else {
+				// Dropped connections are suspended until the grace period runs out.
+				_ = conn.NetConn().Close()
+			}
+			<-client.closed
+		}()
+	}
+	wg.Wait()
+	waitForRooms(t)
+}
+
+// Message types no real message uses, sent straight through the hub to tell targeted messages apart.
+const (
+	testUnicast   byte = 0x70
+	testMulticast byte = 0x71
+	testSentinel  byte = 0x72
+)
+
+// testMessage is a message of the given type with an empty header.
+func testMessage(msg byte) []byte {
+	b := make([]byte, protocol.HeaderSize)
+	b[0] = msg
+	return b
+}
+
+// received reads messages until the sentinel arrives, returning the types of the test messages before it.
+func (c *testClient) received(t *testing.T) []byte {
+	t.Helper()
+	var got []byte
+	for {
+		b := c.waitForAny(t)
+		switch b[0] {
+		case testSentinel:
+			return got
+		case testUnicast, testMulticast:
+			got = append(got, b[0])
+		}
+	}
+}
+
+// waitForAny waits for the next message.
+func (c *testClient) waitForAny(t *testing.T) []byte {
+	t.Helper()
+	select {
+	case b := <-c.messages:
+		return b
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for a message")
+		return nil
+	}
+}
+
+// TestTargetedSends checks a unicast only reaches its client, and a multicast only the listed ones.
+func TestTargetedSends(t *testing.T) {
+	server := newTestServer(t)
+
+	conns := make([]*gws.Conn, 4)
+	clients := make([]*testClient, len(conns))
+	ids := make([]CID, len(conns))
+	for i := range conns {
+		conns[i], clients[i] = dial(t, server, "?room=targeted")
+		welcome := clients[i].waitFor(t, protocol.MsgWelcome)
+		ids[i] = CID(binary.LittleEndian.Uint16(welcome))
+	}
+	rooms.mu.Lock()
+	hub := rooms.rooms["targeted"].hub
+	rooms.mu.Unlock()
+
+	// A client ID that isn't registered is skipped.
+	unused := ids[0]
+	for slices.Contains(ids, unused) {
+		unused++
+	}
+	hub.unicast <- &UnicastMessage{Client: ids[1], Opcode: gws.OpcodeBinary, Payload: testMessage(testUnicast)}
+	hub.multicast <- &MulticastMessage{Clients: []CID{ids[2], unused, ids[3]}, Opcode: gws.OpcodeBinary, Payload: testMessage(testMulticast)}
+	// The hub picks from its channels in any order, so the sentinel only goes out once both were delivered.
+	clients[1].waitFor(t, testUnicast)
+	clients[2].waitFor(t, testMulticast)
+	clients[3].waitFor(t, testMulticast)
+	hub.broadcast <- &OutboundMessage{Opcode: gws.OpcodeBinary, Payload: testMessage(testSentinel)}
+	for i, client := range clients {
+		if got := client.received(t); len(got) != 0 {
+			t.Fatalf("client %d received messages %v meant for others", i, got)
+		}
+	}
+
+	for i := range conns {
+		_ = conns[i].WriteClose(1000, nil)
+		<-clients[i].closed
+	}
+	waitForRooms(t)
+}
+
+func TestResumeKeepsClientID(t *testing.T) {
+	withResumeGrace(t, 5*time.Second)
+	server := newTestServer(t)
+
+	conn, client := dial(t, server, "?room=resume")
+	welcome := client.waitFor(t, protocol.MsgWelcome)
+	_ = conn.NetConn().Close()
+	<-client.closed
+
+	token := hex.EncodeToString(welcome[3:])
+	conn, client = dial(t, server, "?room=resume&resume="+token)
+	resumed := client.waitFor(t, protocol.MsgWelcome)
+	if resumed[2] != 1 {
+		t.Fatal("expected the session to be resumed")
+	}
+	if string(resumed[0:2]) != string(welcome[0:2]) {
+		t.Fatalf("resumed with client ID %v, expected %v", resumed[0:2], welcome[0:2])
+	}
+	_ = conn.WriteClose(1000, nil)
+	<-client.closed
+	waitForRooms(t)
+}
+
+// TestResendsUnackedEvents drops a client's connection without acknowledging its events,
+// then checks they're sent again in order once it resumes, and acknowledged ones aren't.
+func TestResendsUnackedEvents(t *testing.T) {
+	withResumeGrace(t, 5*time.Second)
+	server := newTestServer(t)
+
+	conn, client := dial(t, server, "?room=events")
+	welcome := client.waitFor(t, protocol.MsgWelcome)
+	token := hex.EncodeToString(welcome[3:])
+	roster := client.waitForEvent(t)
+	other, otherClient := dial(t, server, "?room=events")
+	joined := client.waitForEvent(t)
+	if _, ok := joined.Event.(*protocol.Joined); !ok {
+		t.Fatalf("expected a joined event, got %T", joined.Event)
+	}
+	_ = conn.NetConn().Close()
+	<-client.closed
+
+	conn, client = dial(t, server, "?room=events&resume="+token)
+	client.waitFor(t, protocol.MsgWelcome)
+	for _, want := range []*protocol.Event{roster, joined} {
+		if got := client.waitForEvent(t); got.Seq != want.Seq || got.Event.Type() != want.Event.Type() {
+			t.Fatalf("resent event %d of type %d, expected %d of type %d", got.Seq, got.Event.Type(), want.Seq, want.Event.Type())
+		}
+	}
+
+	// Acknowledged events are forgotten, the chat sent after them is the only one left to resend.
+	_ = conn.WriteMessage(gws.OpcodeBinary, protocol.EncodeClient(&protocol.EventAck{Seq: joined.Seq}))
+	_ = other.WriteMessage(gws.OpcodeBinary, protocol.EncodeClient(&protocol.ChatRequest{Text: " hello\x00 "}))
+	chat := client.waitForEvent(t)
+	if m, ok := chat.Event.(*protocol.Chat); !ok || m.Text != "hello" {
+		t.Fatalf("expected a sanitized chat event, got %+v", chat.Event)
+	}
+	_ = conn.NetConn().Close()
+	<-client.closed
+
+	conn, client = dial(t, server, "?room=events&resume="+token)
+	client.waitFor(t, protocol.MsgWelcome)
+	if got := client.waitForEvent(t); got.Seq != chat.Seq {
+		t.Fatalf("resent event %d of type %d, expected only the chat %d", got.Seq, got.Event.Type(), chat.Seq)
+	}
+
+	_ = conn.WriteClose(1000, nil)
+	_ = other.WriteClose(1000, nil)
+	<-client.closed
+	<-otherClient.closed
+	waitForRooms(t)
+}
+
+func TestQuitCannotResume(t *testing.T) {
+	server := newTestServer(t)
+
+	conn, client := dial(t, server, "?room=quit")
+	welcome := client.waitFor(t, protocol.MsgWelcome)
+	_ = conn.WriteClose(1000, nil)
+	<-client.closed
+	waitForRooms(t)
+
+	conn, client = dial(t, server, "?room=quit&resume="+hex.EncodeToString(welcome[3:]))
+	if client.waitFor(t, protocol.MsgWelcome)[2] != 0 {
+		t.Fatal("client that quit was able to resume")
+	}
+	_ = conn.WriteClose(1000, nil)
+	<-client.closed
+	waitForRooms(t)
+}
+
+func withHeartbeat(t *testing.T, interval time.Duration, missed int) {
+	prevInterval, prevMissed := config.HeartbeatInterval, config.MaxMissedHeartbeats
+	config.HeartbeatInterval, config.MaxMissedHeartbeats = interval, missed
+	t.Cleanup(func() { config.HeartbeatInterval, config.MaxMissedHeartbeats = prevInterval, prevMissed })
+}
+
+func withQueueSize(t *testing.T, size int) {
+	prev := config.QueueSize
+	config.QueueSize = size
+	t.Cleanup(func() { config.QueueSize = prev })
+}
+
+// TestHeartbeatWithoutMisses checks clients that answer every ping stay connected,
+// even when not a single missed ping is tolerated.
+func TestHeartbeatWithoutMisses(t *testing.T) {
+	withHeartbeat(t, 50*time.Millisecond, 0)
+	// A ping can race the close and fail writing first, so the client may be suspended rather than quit.
+	withResumeGrace(t, 50*time.Millisecond)
+	server := newTestServer(t)
+
+	conn, client := dial(t, server, "?room=heartbeat")
+	client.waitFor(t, protocol.MsgWelcome)
+	select {
+	case <-client.closed:
+		t.Fatalf("client answering pings was closed with %v", client.err)
+	case <-time.After(10 * config.HeartbeatInterval):
+	}
+	_ = conn.WriteClose(1000, nil)
+	<-client.closed
+	waitForRooms(t)
+}
+
+// TestWaitingClientStaysConnected fills a room, then checks a client queued for a slot
+// outlives the heartbeat timeout and is admitted once a player leaves.
+func TestWaitingClientStaysConnected(t *testing.T) {
+	if raceEnabled {
+		t.Skip("a full room of clients in one process can't keep up with its heartbeats under the race detector")
+	}
+	withHeartbeat(t, 200*time.Millisecond, 2)
+	// Every player runs in this one process, so some may miss heartbeats while the room is busy.
+	// Their slots are held for the grace period, which keeps the room full while the client waits.
+	withResumeGrace(t, 2*time.Second)
+	// A full room's presence events back up in the default queue.
+	withQueueSize(t, 4096)
+	server := newTestServer(t)
+
+	conns := make([]*gws.Conn, MaxClients)
+	clients := make([]*testClient, MaxClients)
+	for i := range conns {
+		// A full room sends every player more join events than are kept unacknowledged.
+		conns[i], clients[i] = dialClient(t, server, "?room=waiting", &testClient{ackAll: true})
+		clients[i].waitFor(t, protocol.MsgWelcome)
+	}
+	conn, client := dial(t, server, "?room=waiting")
+	select {
+	case <-client.closed:
+		t.Fatalf("waiting client was closed with %v", client.err)
+	case <-time.After(2 * config.HeartbeatTimeout()):
+	}
+	for len(client.messages) > 0 {
+		if b := <-client.messages; len(b) > 0 && b[0] == protocol.MsgWelcome {
+			t.Fatal("client was admitted to a full room")
+		}
+	}
+
+	for i := range conns {
+		select {
+		case <-clients[i].closed:
+			continue
+		default:
+		}
+		_ = conns[i].WriteClose(1000, nil)
+		<-clients[i].closed
+		conns[i], clients[i] = conn, client
+		break
+	}
+	client.waitFor(t, protocol.MsgWelcome)
+
+	for i := range conns {
+		_ = conns[i].WriteClose(1000, nil)
+		<-clients[i].closed
+	}
+	waitForRooms(t)
+}
+
+// waitForClose waits for the server to close the connection, returning the close error it sent.
+func (c *testClient) waitForClose(t *testing.T) *gws.CloseError {
+	t.Helper()
+	select {
+	case <-c.closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("connection wasn't closed")
+	}
+	var closeErr *gws.CloseError
+	if !errors.As(c.err, &closeErr) {
+		t.Fatalf("closed with %v, expected a close frame", c.err)
+	}
+	return closeErr
+}
+
+// TestEvictsInvalidMessages checks a few messages that don't decode are tolerated,
+// and a client that keeps sending them is evicted without being able to resume.
+func TestEvictsInvalidMessages(t *testing.T) {
+	withResumeGrace(t, 5*time.Second)
+	server := newTestServer(t)
+
+	conn, client := dial(t, server, "?room=invalid")
+	welcome := client.waitFor(t, protocol.MsgWelcome)
+	invalid := [][]byte{{}, {0xff}, {protocol.MsgInput, 1, 2}, protocol.EncodeClient(&protocol.Ack{Tick: 1})[:3]}
+	for i := range config.MaxDecodeErrors {
+		_ = conn.WriteMessage(gws.OpcodeBinary, invalid[i%len(invalid)])
+	}
+	_ = conn.WriteMessage(gws.OpcodeBinary, protocol.EncodeClient(&protocol.TimeRequest{Sent: 1}))
+	client.waitFor(t, protocol.MsgTime)
+
+	// Text frames are only decoded with the JSON wire format on.
+	_ = conn.WriteMessage(gws.OpcodeText, []byte(`{"type": "ChatRequest", "body": {"text": "hi"}}`))
+	if closeErr := client.waitForClose(t); closeErr.Code != ClosePolicyViolation || string(closeErr.Reason) != ReasonInvalidMessages {
+		t.Fatalf("closed with %v, expected %q", closeErr, ReasonInvalidMessages)
+	}
+	waitForRooms(t)
+
+	conn, client = dial(t, server, "?room=invalid&resume="+hex.EncodeToString(welcome[3:]))
+	if client.waitFor(t, protocol.MsgWelcome)[2] != 0 {
+		t.Fatal("evicted client was able to resume")
+	}
+	_ = conn.WriteClose(1000, nil)
+	<-client.closed
+	waitForRooms(t)
+}
+
+// TestJSONWire checks the server sends JSON text frames with the JSON wire format on,
+// and takes both JSON and binary messages from clients.
+func TestJSONWire(t *testing.T) {
+	if !protocol.JSONWire {
+		t.Skip("the JSON wire format is only built with the jsonwire tag")
+	}
+	config.JSONWire = true
+	t.Cleanup(func() { config.JSONWire = false })
+	server := newTestServer(t)
+
+	conn, client := dial(t, server, "?room=json")
+	// waitFor looks for binary messages, so decode the first JSON one by hand.
+	var welcome *protocol.Welcome
+	select {
+	case b := <-client.messages:
+		h, m, err := protocol.DecodeJSON(b)
+		if err != nil || h.Type != protocol.MsgWelcome {
+			t.Fatalf("expected a JSON welcome, got %s: %v", b, err)
+		}
+		welcome = m.(*protocol.Welcome)
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the welcome")
+	}
+	if welcome.Resumed {
+		t.Fatal("expected a new session")
+	}
+
+	request, err := protocol.EncodeClientJSON(&protocol.TimeRequest{Sent: 12.5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = conn.WriteMessage(gws.OpcodeText, request)
+	_ = conn.WriteMessage(gws.OpcodeBinary, protocol.EncodeClient(&protocol.TimeRequest{Sent: 25}))
+	// Messages are handled in parallel, so the answers can come in either order.
+	pending := map[float64]bool{12.5: true, 25: true}
+	timeout := time.After(5 * time.Second)
+	for len(pending) > 0 {
+		select {
+		case b := <-client.messages:
+			if _, m, err := protocol.DecodeJSON(b); err != nil {
+				t.Fatalf("expected JSON, got %s: %v", b, err)
+			} else if m, ok := m.(*protocol.Time); ok {
+				if !pending[m.ClientSent] {
+					t.Fatalf("answered a request sent at %v, which wasn't sent or was already answered", m.ClientSent)
+				}
+				delete(pending, m.ClientSent)
+			}
+		case <-timeout:
+			t.Fatalf("timed out waiting for the answers to the requests sent at %v", pending)
+		}
+	}
+	_ = conn.WriteClose(1000, nil)
+	<-client.closed
+	waitForRooms(t)
+}
+
+// TestRejectsOversizeMessages sends a message too large to be read, and a small compressed one that inflates past the limit.
+func TestRejectsOversizeMessages(t *testing.T) {
+	// The connection fails reading, so the client is suspended like any other dropped connection.
+	withResumeGrace(t, 50*time.Millisecond)
+	server := newTestServer(t)
+
+	oversize := make([]byte, MaxMessageSize+1)
+	_, _ = rand.Read(oversize)
+	for _, payload := range [][]byte{oversize, make([]byte, 64*MaxMessageSize)} {
+		conn, client := dial(t, server, "?room=oversize")
+		client.waitFor(t, protocol.MsgWelcome)
+		_ = conn.WriteMessage(gws.OpcodeBinary, payload)
+		// Frames that are too large are refused outright, messages that inflate too much fail decompressing.
+		if closeErr := client.waitForClose(t); closeErr.Code != 1009 && closeErr.Code != 1011 {
+			t.Fatalf("closed with %v, expected the message to be too large", closeErr)
+		}
+		waitForRooms(t)
+	}
+}
+
+func TestRejectsUnsupportedVersion(t *testing.T) {
+	server := newTestServer(t)
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws?room=version"
+
+	// Clients that don't negotiate a version at all are turned away before upgrading.
+	if _, resp, err := gws.NewClient(&testClient{}, &gws.ClientOption{Addr: url}); err == nil {
+		t.Fatal("connected without offering a protocol version")
+	} else if resp == nil || resp.StatusCode != http.StatusUpgradeRequired {
+		t.Fatalf("expected upgrade required, got %v", err)
+	}
+
+	// Clients offering only other versions are upgraded, then closed with the reason.
+	// There may not be an older version to offer, TestPickVersion covers which reason is picked.
+	client := &testClient{messages: make(chan []byte, 1), closed: make(chan struct{})}
+	conn, _, err := gws.NewClient(client, &gws.ClientOption{
+		Addr:          url,
+		RequestHeader: http.Header{"Sec-WebSocket-Protocol": {protocol.Subprotocol(protocol.Version + 1)}},
+	})
+	if err != nil {
+		t.Fatalf("expected to be upgraded then closed, got %v", err)
+	}
+	go conn.ReadLoop()
+	select {
+	case <-client.closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("connection wasn't closed")
+	}
+	var closeErr *gws.CloseError
+	if !errors.As(client.err, &closeErr) || closeErr.Code != ClosePolicyViolation || string(closeErr.Reason) != protocol.ReasonServerOutdated {
+		t.Fatalf("closed with %v, expected %q", client.err, protocol.ReasonServerOutdated)
+	}
+}
+
+// TestShutdownClosesClients checks closing every room sends each client a going away close frame,
+// and new clients are turned away after.
+func TestShutdownClosesClients(t *testing.T) {
+	prev := rooms
+	rooms = NewRooms()
+	t.Cleanup(func() { rooms = prev })
+	server := newTestServer(t)
+
+	var clients []*testClient
+	for _, room := range []string{"shutdown", "shutdown", "shutdown-2"} {
+		_, client := dial(t, server, "?room="+room)
+		client.waitFor(t, protocol.MsgWelcome)
+		clients = append(clients, client)
+	}
+	rooms.Close()
+	for _, client := range clients {
+		if closeErr := client.waitForClose(t); closeErr.Code != CloseGoingAway {
+			t.Fatalf("closed with %v, expected going away", closeErr)
+		}
+	}
+
+	if resp := dialStatus(t, server, "?room=shutdown"); resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("got %s after shutting down, expected 503", resp.Status)
+	}
+}
+
+// dialStatus dials the room, expecting the upgrade to be refused, and returns the response.
+func dialStatus(t *testing.T, server *httptest.Server, query string) *http.Response {
+	t.Helper()
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws" + query
+	_, resp, err := gws.NewClient(&testClient{}, &gws.ClientOption{
+		Addr:          url,
+		RequestHeader: http.Header{"Sec-WebSocket-Protocol": {protocol.Subprotocol(protocol.Version)}},
+	})
+	if err == nil {
+		t.Fatalf("dial %s: connected, expected to be refused", url)
+	}
+	if resp == nil {
+		t.Fatalf("dial %s: %v", url, err)
+	}
+	return resp
+}
+
+// TestRejectsFullRoom checks a room that has every client ID and waiting spot taken turns clients away before upgrading.
+func TestRejectsFullRoom(t *testing.T) {
+	server := newTestServer(t)
+
+	// Joining without connecting takes a spot in the room just the same.
+	var room *Room
+	for range MaxClients + MaxWaiting {
+		var err error
+		if room, err = rooms.Join("full"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Cleanup(func() {
+		for range MaxClients + MaxWaiting {
+			rooms.Leave(room)
+		}
+		waitForRooms(t)
+	})
+
+	resp := dialStatus(t, server, "?room=full")
+	if resp.StatusCode != http.StatusServiceUnavailable || resp.Header.Get("Retry-After") != RetryAfter {
+		t.Fatalf("got %s with Retry-After %q, expected 503 with %q", resp.Status, resp.Header.Get("Retry-After"), RetryAfter)
+	}
+}
+
+// TestRejectsTooManyRooms checks new rooms aren't created once the server hosts as many as it can.
+func TestRejectsTooManyRooms(t *testing.T) {
+	server := newTestServer(t)
+
+	joined := make([]*Room, 0, MaxRooms)
+	for i := range MaxRooms {
+		room, err := rooms.Join(fmt.Sprintf("many-%d", i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		joined = append(joined, room)
+	}
+	t.Cleanup(func() {
+		for _, room := range joined {
+			rooms.Leave(room)
+		}
+		waitForRooms(t)
+	})
+
+	resp := dialStatus(t, server, "?room=one-too-many")
+	if resp.StatusCode != http.StatusServiceUnavailable || resp.Header.Get("Retry-After") != RetryAfter {
+		t.Fatalf("got %s with Retry-After %q, expected 503 with %q", resp.Status, resp.Header.Get("Retry-After"), RetryAfter)
+	}
+	// Rooms that already exist still take clients.
+	conn, client := dial(t, server, "?room=many-0")
+	client.waitFor(t, protocol.MsgWelcome)
+	_ = conn.WriteClose(1000, nil)
+	<-client.closed
+}
+
+// TestHubRejectsWhenFull checks a connection the hub has neither an ID nor a waiting spot for is closed as server full.
+// The room turns clients away before that, so the hub's limits are filled in directly.
+func TestHubRejectsWhenFull(t *testing.T) {
+	hub := NewHub(func() {})
+	waiting, _ := newTestConn(t, &gws.BuiltinEventHandler{})
+	// The run loop only reads these once a connection registers, which the channel send orders after.
+	hub.cidPool = hub.cidPool[:0]
+	for range MaxWaiting {
+		hub.waiting = append(hub.waiting, waiting)
+	}
+	t.Cleanup(hub.Close)
+
+	client := &testClient{messages: make(chan []byte, 1), closed: make(chan struct{})}
+	conn, clientConn := newTestConn(t, client)
+	go clientConn.ReadLoop()
+	conn.Session().Store(sessionResume, "")
+	hub.Register(conn)
+	if closeErr := client.waitForClose(t); closeErr.Code != CloseTryAgainLater || string(closeErr.Reason) != ReasonServerFull {
+		t.Fatalf("closed with %v, expected %q", closeErr, ReasonServerFull)
+	}
+}
+
+func TestPickVersion(t *testing.T) {
+	v := protocol.Subprotocol
+	for _, c := range []struct {
+		offered     string
+		ok          bool
+		subprotocol string
+		reason      string
+	}{
+		{v(3), true, v(3), ""},
+		{v(1) + ", " + v(3), true, v(3), ""},
+		{v(5) + "," + v(4), true, v(4), ""},
+		{v(1), false, v(1), protocol.ReasonUpdateRequired},
+		{v(5), false, v(5), protocol.ReasonServerOutdated},
+		// The newest version offered decides which side is out of date.
+		{v(1) + ", " + v(6), false, v(6), protocol.ReasonServerOutdated},
+		{"", false, "", ""},
+		{"chat, " + v(0) + ", " + protocol.SubprotocolPrefix + "x", false, "", ""},
+	} {
+		ok, subprotocol, reason := pickVersion(c.offered, 2, 4)
+		if ok != c.ok || subprotocol != c.subprotocol || reason != c.reason {
+			t.Errorf("offered %q: picked %v, %q, %q, expected %v, %q, %q", c.offered, ok, subprotocol, reason, c.ok, c.subprotocol, c.reason)
+		}
+	}
+}
@@ -1,71 +1,278 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
 	"log"
+	"math"
 	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 	"time"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/lxzan/gws"
+
+	"webgl-multiplayer/protocol"
 )
 
 const (
-	Debug    = true
-	PingWait = 10 * time.Second
+	Debug        = true
+	ShutdownWait = 5 * time.Second
+	RetryAfter   = "5" // seconds
+	MaxNameSize  = 24
+	MaxChatSize  = 200
 )
 
-var hub = NewHub()
-var game = NewGame(hub)
+var rooms = NewRooms()
+
+var (
+	ErrUnsupportedVersion = errors.New("unsupported protocol version")
+	ErrUnexpectedText     = errors.New("text message without the json wire format")
+)
 
 func main() {
-	upgrader := gws.NewUpgrader(&Handler{}, &gws.ServerOption{
+	config.RegisterFlags(flag.CommandLine)
+	flag.Parse()
+	if err := config.Validate(); err != nil {
+		log.Fatal(err)
+	}
+	if config.JSONWire && !protocol.JSONWire {
+		log.Fatal("the json wire format needs a server built with -tags jsonwire")
+	}
+
+	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("hi!"))
+	})
+	http.HandleFunc("/ws", serveWS(newUpgrader()))
+
+	server := &http.Server{Addr: "0.0.0.0:8080"}
+	go func() {
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
+	}()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	<-ctx.Done()
+	log.Println("Shutting down server.")
+
+	// Stop accepting new connections and upgrades first, then disconnect the clients of every room.
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), ShutdownWait)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		log.Println("Error shutting down http server: ", err)
+	}
+	rooms.Close()
+}
+
+func newUpgrader() *gws.Upgrader {
+	readLimit := MaxMessageSize
+	if config.JSONWire {
+		// The same messages take several times the space in JSON, more if their strings need escaping.
+		readLimit *= 8
+	}
+	return gws.NewUpgrader(&Handler{}, &gws.ServerOption{
 		ParallelEnabled:   true,
 		Recovery:          gws.Recovery,
 		PermessageDeflate: gws.PermessageDeflate{Enabled: true},
+		SubProtocols:      protocol.Subprotocols(),
+		// Larger messages close the connection before they're read, compressed ones once they inflate past it.
+		ReadMaxPayloadSize: readLimit,
 	})
-	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		w.Write([]byte("hi!"))
-	})
-	http.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) {
+}
+
+// negotiateVersion checks the client offered a protocol version this server speaks, as a WebSocket subprotocol.
+// Browsers don't tell the page why a handshake failed, so clients that only offered other versions are upgraded anyway
+// and closed with a reason saying which side is out of date. Clients that didn't offer any version get an HTTP error.
+func negotiateVersion(w http.ResponseWriter, r *http.Request) bool {
+	ok, subprotocol, reason := pickVersion(r.Header.Get("Sec-WebSocket-Protocol"), protocol.MinVersion, protocol.Version)
+	if ok {
+		return true
+	}
+	if subprotocol == "" {
+		http.Error(w, ErrUnsupportedVersion.Error(), http.StatusUpgradeRequired)
+		return false
+	}
+
+	upgrader := gws.NewUpgrader(&gws.BuiltinEventHandler{}, &gws.ServerOption{SubProtocols: []string{subprotocol}})
+	conn, err := upgrader.Upgrade(w, r)
+	if err != nil {
+		return false
+	}
+	_ = conn.SetWriteDeadline(time.Now().Add(CloseWait))
+	_ = conn.WriteClose(ClosePolicyViolation, []byte(reason))
+	_ = conn.NetConn().Close()
+	if Debug {
+		log.Println("client rejected, reason: ", reason, ", offered: ", r.Header.Get("Sec-WebSocket-Protocol"))
+	}
+	return false
+}
+
+// pickVersion checks the offered subprotocols for a version from minVersion to maxVersion.
+// If there's none, it returns the newest version offered and the reason it's turned away with,
+// or no subprotocol if none of the offered ones is a version at all.
+func pickVersion(offered string, minVersion, maxVersion int) (ok bool, subprotocol, reason string) {
+	newest := 0
+	for _, s := range strings.Split(offered, ",") {
+		s = strings.TrimSpace(s)
+		v, ok := protocol.ParseSubprotocol(s)
+		if !ok {
+			continue
+		}
+		if v >= minVersion && v <= maxVersion {
+			return true, s, ""
+		}
+		if v > newest {
+			newest, subprotocol = v, s
+		}
+	}
+	if subprotocol == "" {
+		return false, "", ""
+	}
+	if newest < minVersion {
+		return false, subprotocol, protocol.ReasonUpdateRequired
+	}
+	return false, subprotocol, protocol.ReasonServerOutdated
+}
+
+// serveWS routes WebSocket upgrades to the room in the query, creating it if needed.
+func serveWS(upgrader *gws.Upgrader) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !negotiateVersion(w, r) {
+			return
+		}
+		room, err := rooms.Join(r.URL.Query().Get("room"))
+		if errors.Is(err, ErrRoomFull) || errors.Is(err, ErrTooManyRooms) || errors.Is(err, ErrShuttingDown) {
+			w.Header().Set("Retry-After", RetryAfter)
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
 		conn, err := upgrader.Upgrade(w, r)
 		if err != nil {
+			rooms.Leave(room)
 			return
 		}
+		conn.Session().Store(sessionRoom, room)
+		conn.Session().Store(sessionResume, r.URL.Query().Get("resume"))
+		conn.Session().Store(sessionName, displayName(r.URL.Query().Get("name")))
 		go func() {
 			conn.ReadLoop()
 		}()
-	})
+	}
+}
 
-	go game.Run()
+// displayName strips the name down to at most MaxNameSize printable characters.
+func displayName(name string) string {
+	return sanitize(name, MaxNameSize)
+}
 
-	http.ListenAndServe("0.0.0.0:8080", nil)
+// sanitize strips text from a client down to at most size printable characters,
+// and to as many whole characters as fit in a length prefixed string on the wire.
+func sanitize(text string, size int) string {
+	text = strings.Map(func(r rune) rune {
+		if !unicode.IsPrint(r) {
+			return -1
+		}
+		return r
+	}, strings.TrimSpace(text))
+	if runes := []rune(text); len(runes) > size {
+		text = string(runes[:size])
+	}
+	for len(text) > math.MaxUint8 {
+		_, n := utf8.DecodeLastRuneInString(text)
+		text = text[:len(text)-n]
+	}
+	return text
 }
 
+// Connection session storage keys.
+// The session is the only per-connection state that's safe to use from the connection's handlers.
+const (
+	sessionRoom    = "room"    // *Room the connection was routed to
+	sessionResume  = "resume"  // resume token presented by the client, if any
+	sessionName    = "name"    // display name requested by the client
+	sessionClient  = "client"  // *Client, once registered with the hub
+	sessionWaiting = "waiting" // set while queued for a free slot
+)
+
 type Handler struct{}
 
+// connRoom returns the room the connection was routed to when it was upgraded.
+func connRoom(conn *gws.Conn) *Room {
+	room, _ := conn.Session().Load(sessionRoom)
+	return room.(*Room)
+}
+
 func (c *Handler) OnOpen(conn *gws.Conn) {
-	_ = conn.SetDeadline(time.Now().Add(time.Hour * 12))
-	hub.register <- conn
+	_ = conn.SetReadDeadline(time.Now().Add(config.HeartbeatTimeout()))
+	connRoom(conn).hub.Register(conn)
 }
 
 func (c *Handler) OnClose(conn *gws.Conn, err error) {
 	conn.NetConn().Close()
-	hub.unregister <- conn
+	connRoom(conn).hub.Unregister(conn, err)
 }
 
 func (c *Handler) OnPing(conn *gws.Conn, payload []byte) {
-	_ = conn.SetDeadline(time.Now().Add(PingWait))
+	_ = conn.SetReadDeadline(time.Now().Add(config.HeartbeatTimeout()))
 	_ = conn.WritePong(nil)
 }
 
-func (c *Handler) OnPong(socket *gws.Conn, payload []byte) {}
+// OnPong answers the heartbeat, browsers reply to pings automatically.
+func (c *Handler) OnPong(conn *gws.Conn, payload []byte) {
+	_ = conn.SetReadDeadline(time.Now().Add(config.HeartbeatTimeout()))
+	if client, ok := conn.Session().Load(sessionClient); ok {
+		client.(*Client).onPong(payload)
+	}
+}
+
+// decodeMessage decodes a client message, from JSON if it came in a text frame.
+// Clients may send either with the JSON wire format on, whichever the server sends, and only binary with it off.
+func decodeMessage(message *gws.Message) (protocol.Message, error) {
+	if message.Opcode == gws.OpcodeText {
+		if !config.JSONWire {
+			return nil, ErrUnexpectedText
+		}
+		return protocol.DecodeClientJSON(message.Bytes())
+	}
+	return protocol.DecodeClient(message.Bytes())
+}
 
 func (c *Handler) OnMessage(conn *gws.Conn, message *gws.Message) {
+	received := time.Since(epoch)
 	defer message.Close()
-	if client, ok := hub.Clients[hub.connections[conn]]; ok {
-		game.inbound <- &InboundMessage{
-			Client:  client,
-			Payload: message.Bytes(),
+	if client, ok := conn.Session().Load(sessionClient); ok {
+		// Decoded messages don't keep references to the message's buffer, which goes back to a pool once it's closed.
+		m, err := decodeMessage(message)
+		if err != nil {
+			if Debug {
+				log.Println("invalid message from ", client.(*Client).ID, ": ", err)
+			}
+			client.(*Client).invalidMessage(conn)
+			return
+		}
+		switch m := m.(type) {
+		case *protocol.TimeRequest:
+			connRoom(conn).game.syncClock(conn, m, received)
+			return
+		case *protocol.EventAck:
+			// Acknowledgements only touch the client's own event log, so they don't wait for the next tick.
+			client.(*Client).ackEvents(m.Seq)
+			return
 		}
+		connRoom(conn).game.Receive(&InboundMessage{Client: client.(*Client), Message: m})
+	} else if _, waiting := conn.Session().Load(sessionWaiting); waiting {
+		// Clients waiting for a free slot can't play yet.
+		return
 	} else {
 		conn.NetConn().Close()
 		if Debug {
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/hex"
+	"log"
+	"math"
+	"time"
+
+	"github.com/lxzan/gws"
+
+	"webgl-multiplayer/protocol"
+)
+
+// encode encodes a server message sent on the given tick, in JSON if the JSON wire format is on.
+func encode(tick uint32, m protocol.Message) []byte {
+	if !config.JSONWire {
+		return protocol.Encode(tick, m)
+	}
+	b, err := protocol.EncodeJSON(tick, m)
+	if err != nil {
+		// Only floats JSON can't represent fail, the client drops the empty message as invalid.
+		log.Println("encoding json: ", err)
+	}
+	return b
+}
+
+// wireOpcode is the frame type messages are sent in, text for JSON so devtools shows it as such.
+func wireOpcode() gws.Opcode {
+	if config.JSONWire {
+		return gws.OpcodeText
+	}
+	return gws.OpcodeBinary
+}
+
+// encodeWelcome encodes the first message a client gets after registering or resuming.
+func encodeWelcome(tick uint32, client *Client, resumed bool) []byte {
+	m := &protocol.Welcome{Client: uint16(client.ID), Resumed: resumed}
+	_, _ = hex.Decode(m.Token[:], []byte(client.token))
+	return encode(tick, m)
+}
+
+// encodeEvent encodes a reliable event with its sequence number.
+func encodeEvent(tick uint32, seq uint32, m protocol.Message) []byte {
+	return encode(tick, &protocol.Event{Seq: seq, Event: m})
+}
+
+// newRoster lists every client in the game, sent to new clients when they join.
+func newRoster(clients map[CID]*Client) *protocol.Roster {
+	m := &protocol.Roster{Clients: make([]protocol.RosterEntry, 0, len(clients))}
+	for id, client := range clients {
+		m.Clients = append(m.Clients, protocol.RosterEntry{Client: uint16(id), Name: client.Name})
+	}
+	return m
+}
+
+// encodeLatencies encodes every client's round trip time for the scoreboard.
+func encodeLatencies(tick uint32, clients map[CID]*Client) []byte {
+	m := &protocol.Latencies{Clients: make([]protocol.Latency, 0, len(clients))}
+	for id, client := range clients {
+		rtt, _ := client.Latency()
+		m.Clients = append(m.Clients, protocol.Latency{
+			Client: uint16(id),
+			RTT:    uint16(min(rtt.Milliseconds(), math.MaxUint16)),
+		})
+	}
+	return encode(tick, m)
+}
+
+// encodeSnapshot encodes the changes from the baseline snapshot to the current one,
+// or the whole snapshot if there's no baseline.
+func encodeSnapshot(current *snapshot, baseline *snapshot) []byte {
+	m := &protocol.Snapshot{Baseline: current.tick}
+	if baseline == nil {
+		m.Entities = make([]protocol.EntityUpdate, 0, len(current.entities))
+		for i := range current.entities {
+			m.Entities = append(m.Entities, current.entities[i].update(protocol.FieldsAll))
+		}
+		return encode(current.tick, m)
+	}
+
+	m.Baseline = baseline.tick
+	// Both snapshots are sorted by entity slot, so walk them together.
+	i, j := 0, 0
+	for i < len(current.entities) || j < len(baseline.entities) {
+		switch {
+		case j == len(baseline.entities) || (i < len(current.entities) && current.entities[i].Entity.Index() < baseline.entities[j].Entity.Index()):
+			m.Entities = append(m.Entities, current.entities[i].update(protocol.FieldsAll))
+			i++
+		case i == len(current.entities) || current.entities[i].Entity.Index() > baseline.entities[j].Entity.Index():
+			m.Removed = append(m.Removed, uint32(baseline.entities[j].Entity))
+			j++
+		case current.entities[i].Entity != baseline.entities[j].Entity:
+			// The slot was reused by a new entity.
+			m.Removed = append(m.Removed, uint32(baseline.entities[j].Entity))
+			m.Entities = append(m.Entities, current.entities[i].update(protocol.FieldsAll))
+			i++
+			j++
+		default:
+			if fields := current.entities[i].changes(&baseline.entities[j]); fields != 0 {
+				m.Entities = append(m.Entities, current.entities[i].update(fields))
+			}
+			i++
+			j++
+		}
+	}
+	return encode(current.tick, m)
+}
+
+// encodeTime encodes the answer to a clock sync request.
+// All server times are in milliseconds since the server started.
+func encodeTime(tick uint32, clientSent float64, received time.Duration, origin time.Duration, interval time.Duration) []byte {
+	return encode(tick, &protocol.Time{
+		ClientSent:     clientSent,
+		ServerReceived: milliseconds(received),
+		ServerSent:     milliseconds(time.Since(epoch)),
+		TickOrigin:     milliseconds(origin),
+		TickInterval:   milliseconds(interval),
+	})
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+const (
+	DefaultRoom   = "lobby"
+	MaxRooms      = 64
+	MaxRoomIDSize = 32
+)
+
+var (
+	ErrInvalidRoomID = errors.New("invalid room id")
+	ErrTooManyRooms  = errors.New("too many rooms")
+	ErrShuttingDown  = errors.New("server shutting down")
+	ErrRoomFull      = errors.New(ReasonServerFull)
+)
+
+// Room is a single game instance, with its own hub, client ID pool, and tick loop.
+type Room struct {
+	ID      string
+	hub     *Hub
+	game    *Game
+	members int
+}
+
+// NewRoom creates a room and starts its hub and game loop.
+// The room leaves the registry once the hub released every client's slot.
+func NewRoom(id string, rooms *Rooms) *Room {
+	room := &Room{ID: id}
+	room.hub = NewHub(func() {
+		// Called from the hub's run loop, which closing the room waits on.
+		go rooms.Leave(room)
+	})
+	room.game = NewGame(room.hub)
+	room.game.Run()
+	return room
+}
+
+// Close lets the current tick finish, then disconnects the room's clients and stops its hub.
+func (r *Room) Close() {
+	r.game.Stop()
+	r.hub.Close()
+}
+
+// Rooms is a registry of the active rooms.
+// Rooms are created on demand when the first client joins and closed once the last one leaves.
+type Rooms struct {
+	mu      sync.Mutex
+	rooms   map[string]*Room
+	closing bool
+}
+
+func NewRooms() *Rooms {
+	return &Rooms{
+		rooms: make(map[string]*Room),
+	}
+}
+
+// Join returns the room with the given ID, creating it if it doesn't exist yet.
+// Every successful call must be matched by a call to Leave, which the room's hub does once the client's slot is freed.
+func (r *Rooms) Join(id string) (*Room, error) {
+	if id == "" {
+		id = DefaultRoom
+	}
+	if !validRoomID(id) {
+		return nil, ErrInvalidRoomID
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closing {
+		return nil, ErrShuttingDown
+	}
+	room, ok := r.rooms[id]
+	if !ok {
+		if len(r.rooms) >= MaxRooms {
+			return nil, ErrTooManyRooms
+		}
+		room = NewRoom(id, r)
+		r.rooms[id] = room
+		if Debug {
+			fmt.Println("room created, id: ", id)
+		}
+	}
+	// This is only an early check to avoid the upgrade, the hub has the final say when the client registers.
+	if room.members >= MaxClients+MaxWaiting {
+		return nil, ErrRoomFull
+	}
+	room.members++
+	return room, nil
+}
+
+// Leave releases a client's membership of the room, tearing the room down when it's empty.
+func (r *Rooms) Leave(room *Room) {
+	r.mu.Lock()
+	room.members--
+	// The room may have already been closed by a shutdown.
+	empty := room.members == 0 && r.rooms[room.ID] == room
+	if empty {
+		delete(r.rooms, room.ID)
+	}
+	r.mu.Unlock()
+
+	if empty {
+		room.Close()
+		if Debug {
+			fmt.Println("room closed, id: ", room.ID)
+		}
+	}
+}
+
+// Close stops accepting new clients and closes every room, waiting for their hubs and game loops to exit.
+func (r *Rooms) Close() {
+	r.mu.Lock()
+	r.closing = true
+	closing := r.rooms
+	r.rooms = make(map[string]*Room)
+	r.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for _, room := range closing {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			room.Close()
+		}()
+	}
+	wg.Wait()
+}
+
+// Room IDs are kept to a short set of url-safe characters.
+func validRoomID(id string) bool {
+	if len(id) > MaxRoomIDSize {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '-', c == '_':
+		default:
+			return false
+		}
+	}
+	return true
+}
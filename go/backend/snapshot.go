@@ -0,0 +1,162 @@
+package main
+
+import (
+	"expvar"
+	"slices"
+
+	"webgl-multiplayer/protocol"
+)
+
+// SnapshotBufferSize is the number of recent snapshots kept as delta baselines.
+// Clients whose last acknowledged snapshot is older than this get a full snapshot instead.
+const SnapshotBufferSize = 32
+
+// NoOwner is the owner of entities that don't belong to a client.
+const NoOwner CID = protocol.NoOwner
+
+// Snapshot counters for all rooms, served on /debug/vars.
+var (
+	fullSnapshots  = expvar.NewInt("snapshots_full")
+	deltaSnapshots = expvar.NewInt("snapshots_delta")
+)
+
+// entityState is the replicated part of an entity.
+type entityState struct {
+	Entity   Entity
+	Owner    CID
+	Model    ModelID
+	Position [3]float32
+	Rotation [4]float32
+	Velocity Velocity
+	InputSeq uint32 // last input command applied, so the owner can reconcile its prediction
+}
+
+// changes returns the mask of fields that differ from the baseline.
+func (s *entityState) changes(baseline *entityState) uint8 {
+	var fields uint8
+	if s.Owner != baseline.Owner {
+		fields |= protocol.FieldOwner
+	}
+	if s.Model != baseline.Model {
+		fields |= protocol.FieldModel
+	}
+	if s.Position != baseline.Position {
+		fields |= protocol.FieldPosition
+	}
+	if s.Rotation != baseline.Rotation {
+		fields |= protocol.FieldRotation
+	}
+	if s.Velocity != baseline.Velocity {
+		fields |= protocol.FieldVelocity
+	}
+	if s.InputSeq != baseline.InputSeq {
+		fields |= protocol.FieldInputSeq
+	}
+	return fields
+}
+
+// update returns the entity's fields in the mask, to send in a snapshot.
+func (s *entityState) update(fields uint8) protocol.EntityUpdate {
+	return protocol.EntityUpdate{
+		ID:       uint32(s.Entity),
+		Fields:   fields,
+		Owner:    uint16(s.Owner),
+		Model:    uint8(s.Model),
+		Position: s.Position,
+		Rotation: s.Rotation,
+		Velocity: [3]float32(s.Velocity),
+		InputSeq: s.InputSeq,
+	}
+}
+
+// snapshot is the replicated world state at the end of a tick, sorted by entity.
+type snapshot struct {
+	tick     uint32
+	entities []entityState
+}
+
+// captureSnapshot copies the state of every entity with a model and transform.
+func captureSnapshot(tick uint32, w *World) *snapshot {
+	s := &snapshot{tick: tick, entities: make([]entityState, 0, w.Models.Len())}
+	w.Models.Each(func(e Entity, m *Model) {
+		t := w.Transforms.Get(e)
+		if t == nil {
+			return
+		}
+		state := entityState{
+			Entity:   e,
+			Owner:    NoOwner,
+			Model:    m.ID,
+			Position: t.Position,
+			Rotation: t.Rotation,
+		}
+		if owner := w.Owners.Get(e); owner != nil {
+			state.Owner = owner.Client
+		}
+		if v := w.Velocities.Get(e); v != nil {
+			state.Velocity = *v
+		}
+		if c := w.Controllers.Get(e); c != nil {
+			state.InputSeq = c.Input.Seq
+		}
+		s.entities = append(s.entities, state)
+	})
+	slices.SortFunc(s.entities, func(a, b entityState) int {
+		return int(a.Entity.Index()) - int(b.Entity.Index())
+	})
+	return s
+}
+
+// snapshot returns the snapshot of the given tick, if it's still buffered.
+func (g *Game) snapshot(tick uint32) *snapshot {
+	s := g.snapshots[tick%SnapshotBufferSize]
+	if s == nil || s.tick != tick {
+		return nil
+	}
+	return s
+}
+
+// onAck records the latest snapshot a client received, its baseline for the following deltas.
+// Acks for snapshots that were never sent, or older than the current baseline, are ignored.
+func (g *Game) onAck(id CID, tick uint32) {
+	if int32(tick-g.tickNum) >= 0 {
+		return
+	}
+	if acked, ok := g.acks[id]; ok && int32(tick-acked) <= 0 {
+		return
+	}
+	g.acks[id] = tick
+}
+
+// sendSnapshots captures this tick's snapshot, and sends every client the changes since the last one it acknowledged.
+// Clients sharing a baseline share the same encoded message, so it's only compressed once for all of them.
+func (g *Game) sendSnapshots() {
+	current := captureSnapshot(g.tickNum, g.world)
+	g.snapshots[g.tickNum%SnapshotBufferSize] = current
+
+	groups := make(map[uint32][]CID)
+	for id := range g.clients {
+		baseline := current.tick // full snapshot
+		if acked, ok := g.acks[id]; ok && g.snapshot(acked) != nil {
+			baseline = acked
+		}
+		groups[baseline] = append(groups[baseline], id)
+	}
+
+	for baseline, ids := range groups {
+		var payload []byte
+		if baseline == current.tick {
+			payload = encodeSnapshot(current, nil)
+			fullSnapshots.Add(int64(len(ids)))
+		} else {
+			payload = encodeSnapshot(current, g.snapshot(baseline))
+			deltaSnapshots.Add(int64(len(ids)))
+		}
+		g.hub.multicast <- &MulticastMessage{
+			Clients: ids,
+			Opcode:  wireOpcode(),
+			Payload: payload,
+			State:   true,
+		}
+	}
+}
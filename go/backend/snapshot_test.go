@@ -0,0 +1,285 @@
+package main
+
+import (
+	"maps"
+	"math/rand/v2"
+	"slices"
+	"testing"
+
+	"webgl-multiplayer/protocol"
+)
+
+// decodeSnapshot decodes a snapshot encoded by encodeSnapshot.
+func decodeSnapshot(t *testing.T, b []byte) *protocol.Snapshot {
+	t.Helper()
+	_, m, err := protocol.Decode(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, ok := m.(*protocol.Snapshot)
+	if !ok {
+		t.Fatalf("encoded a %T, not a snapshot", m)
+	}
+	return s
+}
+
+// received is the state a client has from a full snapshot, the way it decoded it, by entity.
+func received(t *testing.T, s *snapshot) map[uint32]protocol.EntityUpdate {
+	t.Helper()
+	entities := make(map[uint32]protocol.EntityUpdate)
+	for _, u := range decodeSnapshot(t, encodeSnapshot(s, nil)).Entities {
+		if u.Fields != protocol.FieldsAll {
+			t.Fatalf("entity %#x has fields %#x in a full snapshot", u.ID, u.Fields)
+		}
+		entities[u.ID] = u
+	}
+	return entities
+}
+
+// applyDelta applies a delta to the state a client has, the way clients do.
+func applyDelta(t *testing.T, state map[uint32]protocol.EntityUpdate, delta *protocol.Snapshot) map[uint32]protocol.EntityUpdate {
+	t.Helper()
+	state = maps.Clone(state)
+	for _, id := range delta.Removed {
+		if _, ok := state[id]; !ok {
+			t.Fatalf("removed entity %#x that isn't in the baseline", id)
+		}
+		delete(state, id)
+	}
+	for _, u := range delta.Entities {
+		e, ok := state[u.ID]
+		if !ok {
+			if u.Fields != protocol.FieldsAll {
+				t.Fatalf("added entity %#x with only fields %#x", u.ID, u.Fields)
+			}
+			state[u.ID] = u
+			continue
+		}
+		if u.Fields&protocol.FieldOwner != 0 {
+			e.Owner = u.Owner
+		}
+		if u.Fields&protocol.FieldModel != 0 {
+			e.Model = u.Model
+		}
+		if u.Fields&protocol.FieldPosition != 0 {
+			e.Position = u.Position
+		}
+		if u.Fields&protocol.FieldRotation != 0 {
+			e.Rotation = u.Rotation
+		}
+		if u.Fields&protocol.FieldVelocity != 0 {
+			e.Velocity = u.Velocity
+		}
+		if u.Fields&protocol.FieldInputSeq != 0 {
+			e.InputSeq = u.InputSeq
+		}
+		state[u.ID] = e
+	}
+	return state
+}
+
+// checkDelta encodes the delta from baseline to current, and checks applying it to the baseline gives the current snapshot.
+func checkDelta(t *testing.T, current, baseline *snapshot) *protocol.Snapshot {
+	t.Helper()
+	delta := decodeSnapshot(t, encodeSnapshot(current, baseline))
+	if delta.Baseline != baseline.tick {
+		t.Fatalf("delta from baseline %d, expected %d", delta.Baseline, baseline.tick)
+	}
+	got := applyDelta(t, received(t, baseline), delta)
+	want := received(t, current)
+	if !maps.Equal(got, want) {
+		t.Fatalf("applying the delta gives\n%+v\nexpected\n%+v", got, want)
+	}
+	return delta
+}
+
+func testEntity(index, generation uint16) entityState {
+	return entityState{
+		Entity:   newEntity(index, generation),
+		Owner:    NoOwner,
+		Model:    1,
+		Position: [3]float32{float32(index), 0, 1},
+		Rotation: [4]float32{0, 0, 0, 1},
+	}
+}
+
+func TestSnapshotDelta(t *testing.T) {
+	moved := testEntity(1, 1)
+	moved.Position[1] = 2
+	reused := testEntity(3, 3)
+	reused.Owner = 4
+	baseline := &snapshot{tick: 10, entities: []entityState{
+		testEntity(0, 1),
+		testEntity(1, 1),
+		testEntity(3, 1),
+		testEntity(5, 1),
+	}}
+	current := &snapshot{tick: 12, entities: []entityState{
+		testEntity(0, 1), // unchanged
+		moved,
+		testEntity(2, 1), // added between two kept entities
+		reused,           // the slot of a destroyed entity
+		// slot 5 removed
+		testEntity(7, 1), // added after the baseline's last entity
+	}}
+	delta := checkDelta(t, current, baseline)
+
+	fields := make(map[uint32]uint8)
+	for _, u := range delta.Entities {
+		fields[u.ID] = u.Fields
+	}
+	want := map[uint32]uint8{
+		uint32(moved.Entity):    protocol.FieldPosition,
+		uint32(newEntity(2, 1)): protocol.FieldsAll,
+		uint32(reused.Entity):   protocol.FieldsAll,
+		uint32(newEntity(7, 1)): protocol.FieldsAll,
+	}
+	if !maps.Equal(fields, want) {
+		t.Fatalf("sent entities with fields %v, expected %v", fields, want)
+	}
+	removed := slices.Sorted(slices.Values(delta.Removed))
+	if !slices.Equal(removed, []uint32{uint32(newEntity(3, 1)), uint32(newEntity(5, 1))}) {
+		t.Fatalf("removed %#x, expected the reused slot's old entity and slot 5", removed)
+	}
+
+	// Everything removed, and everything added.
+	empty := &snapshot{tick: 11}
+	if delta := checkDelta(t, empty, baseline); len(delta.Entities) != 0 || len(delta.Removed) != len(baseline.entities) {
+		t.Fatalf("delta to an empty snapshot sends %d entities and removes %d", len(delta.Entities), len(delta.Removed))
+	}
+	if delta := checkDelta(t, current, empty); len(delta.Entities) != len(current.entities) || len(delta.Removed) != 0 {
+		t.Fatalf("delta from an empty snapshot sends %d entities and removes %d", len(delta.Entities), len(delta.Removed))
+	}
+	if delta := checkDelta(t, current, current); len(delta.Entities) != 0 || len(delta.Removed) != 0 {
+		t.Fatal("delta from the same snapshot isn't empty")
+	}
+}
+
+// TestSnapshotChanges checks a delta only carries the fields that changed.
+func TestSnapshotChanges(t *testing.T) {
+	changes := []struct {
+		field  uint8
+		change func(*entityState)
+	}{
+		{protocol.FieldOwner, func(s *entityState) { s.Owner = 2 }},
+		{protocol.FieldModel, func(s *entityState) { s.Model = 3 }},
+		{protocol.FieldPosition, func(s *entityState) { s.Position[2] = -4 }},
+		{protocol.FieldRotation, func(s *entityState) { s.Rotation = [4]float32{0, 1, 0, 0} }},
+		{protocol.FieldVelocity, func(s *entityState) { s.Velocity[0] = 5 }},
+		{protocol.FieldInputSeq, func(s *entityState) { s.InputSeq = 6 }},
+	}
+	baseline := &snapshot{tick: 1, entities: []entityState{testEntity(0, 1)}}
+	all := baseline.entities[0]
+	for _, c := range changes {
+		changed := baseline.entities[0]
+		c.change(&changed)
+		c.change(&all)
+		delta := checkDelta(t, &snapshot{tick: 2, entities: []entityState{changed}}, baseline)
+		if len(delta.Entities) != 1 || delta.Entities[0].Fields != c.field {
+			t.Fatalf("changing field %#x sent %+v", c.field, delta.Entities)
+		}
+	}
+	delta := checkDelta(t, &snapshot{tick: 2, entities: []entityState{all}}, baseline)
+	if len(delta.Entities) != 1 || delta.Entities[0].Fields != protocol.FieldsAll || len(delta.Removed) != 0 {
+		t.Fatalf("changing every field sent %+v and removed %#x", delta.Entities, delta.Removed)
+	}
+}
+
+// randomSnapshot is a snapshot of some of the first few slots, in random generations and states.
+func randomSnapshot(r *rand.Rand, tick uint32) *snapshot {
+	s := &snapshot{tick: tick}
+	for index := range uint16(16) {
+		if r.IntN(2) == 0 {
+			continue
+		}
+		e := testEntity(index, uint16(r.IntN(2))*2+1)
+		if r.IntN(2) == 0 {
+			e.Owner = CID(r.IntN(2))
+		}
+		e.Model = ModelID(r.IntN(2))
+		e.Position[0] = float32(r.IntN(3))
+		e.Rotation = [][4]float32{{0, 0, 0, 1}, {0, 1, 0, 0}}[r.IntN(2)]
+		e.Velocity[1] = float32(r.IntN(2))
+		e.InputSeq = uint32(r.IntN(2))
+		s.entities = append(s.entities, e)
+	}
+	return s
+}
+
+// TestSnapshotDeltaRandom checks deltas between random snapshots, which walk every interleaving of kept, added, removed and reused slots.
+func TestSnapshotDeltaRandom(t *testing.T) {
+	r := rand.New(rand.NewPCG(1, 2))
+	for range 1000 {
+		checkDelta(t, randomSnapshot(r, 2), randomSnapshot(r, 1))
+	}
+}
+
+// TestSnapshotFallback checks clients get a full snapshot once their acked baseline is no longer buffered.
+func TestSnapshotFallback(t *testing.T) {
+	g := NewGame(NewHub(nil))
+	e, err := spawnPlayer(g.world, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g.clients[1] = &Client{}
+
+	// sendSnapshots sends the snapshot of the tick, and returns its baseline.
+	send := func(tick uint32) uint32 {
+		t.Helper()
+		g.tickNum = tick
+		g.sendSnapshots()
+		m := <-g.hub.multicast
+		if !slices.Equal(m.Clients, []CID{1}) {
+			t.Fatalf("sent the snapshot to %v", m.Clients)
+		}
+		s := decodeSnapshot(t, m.Payload)
+		if s.Baseline == tick && (len(s.Entities) != 1 || s.Entities[0].ID != uint32(e)) {
+			t.Fatalf("full snapshot of %+v", s.Entities)
+		}
+		return s.Baseline
+	}
+
+	if baseline := send(1); baseline != 1 {
+		t.Fatalf("first snapshot is a delta from %d, expected a full snapshot", baseline)
+	}
+	g.tickNum = 2
+	g.onAck(1, 1)
+	for tick := uint32(2); tick <= SnapshotBufferSize; tick++ {
+		if baseline := send(tick); baseline != 1 {
+			t.Fatalf("snapshot %d is from baseline %d, expected the acked 1", tick, baseline)
+		}
+	}
+	// The acked snapshot's slot in the ring now holds this one.
+	tick := uint32(1 + SnapshotBufferSize)
+	if baseline := send(tick); baseline != tick {
+		t.Fatalf("snapshot %d is from baseline %d, expected a full snapshot since 1 isn't buffered anymore", tick, baseline)
+	}
+	if fallback := g.snapshot(1); fallback != nil {
+		t.Fatal("snapshot 1 is still buffered after the ring wrapped")
+	}
+}
+
+// TestAckAfterLeave checks an ack still queued when its client left doesn't bring back its baseline,
+// not even once another client reuses the ID.
+func TestAckAfterLeave(t *testing.T) {
+	g := NewGame(NewHub(nil))
+	g.tickNum = 2
+	left := &Client{ID: 1}
+	g.onPresence(&Presence{Client: left, Joined: true})
+	g.onPresence(&Presence{Client: left, Reason: LeaveQuit})
+	g.onMessage(&InboundMessage{Client: left, Message: &protocol.Ack{Tick: 1}})
+	if _, ok := g.acks[1]; ok {
+		t.Fatal("ack from a client that left was recorded")
+	}
+
+	joined := &Client{ID: 1}
+	g.onPresence(&Presence{Client: joined, Joined: true})
+	g.onMessage(&InboundMessage{Client: left, Message: &protocol.Ack{Tick: 1}})
+	if _, ok := g.acks[1]; ok {
+		t.Fatal("ack from a client that left was recorded for the client reusing its ID")
+	}
+	g.onMessage(&InboundMessage{Client: joined, Message: &protocol.Ack{Tick: 1}})
+	if acked, ok := g.acks[1]; !ok || acked != 1 {
+		t.Fatal("ack from the client reusing the ID wasn't recorded")
+	}
+}
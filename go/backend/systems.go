@@ -0,0 +1,120 @@
+package main
+
+import (
+	"math"
+	"slices"
+
+	"webgl-multiplayer/movement"
+	"webgl-multiplayer/protocol"
+)
+
+const (
+	SpawnRadius = 8.0
+
+	InputBufferTicks = 2 // input commands buffered before they're applied, to absorb jitter
+	MaxInputBuffer   = 8 // input commands buffered at most, the oldest are dropped past this
+)
+
+// Input is a client's input command for a single tick.
+type Input struct {
+	Seq uint32
+	movement.Input
+}
+
+// newWorld creates the game's world with its systems, in the order they run each tick.
+func newWorld() *World {
+	w := NewWorld()
+	w.AddSystem(characterSystem)
+	w.AddSystem(movementSystem)
+	return w
+}
+
+// spawnPlayer creates the client's player, spread out on a circle around the origin so players don't stack up.
+func spawnPlayer(w *World, id CID) (Entity, error) {
+	e, err := w.Spawn()
+	if err != nil {
+		return 0, err
+	}
+	angle := float64(id) * math.Pi * (3 - math.Sqrt(5)) // golden angle
+	w.Transforms.Add(e, newTransform(protocol.Position.Round([3]float32{
+		float32(SpawnRadius * math.Cos(angle)),
+		0,
+		float32(SpawnRadius * math.Sin(angle)),
+	})))
+	w.Velocities.Add(e, Velocity{})
+	w.Models.Add(e, Model{ID: ModelMonke})
+	w.Materials.Add(e, Material{Metallic: 0, Roughness: 1, AO: 1})
+	w.Owners.Add(e, Owner{Client: id})
+	w.Controllers.Add(e, Controller{buffering: true})
+	return e, nil
+}
+
+// queueInput buffers an input command, clamping the look angles to the ranges the client can produce.
+// Duplicates and commands older than the last one applied are dropped.
+func (c *Controller) queueInput(in Input) {
+	if int32(in.Seq-c.Input.Seq) <= 0 {
+		return
+	}
+	i, found := slices.BinarySearchFunc(c.inputs, in.Seq, func(queued Input, seq uint32) int {
+		return int(int32(queued.Seq - seq))
+	})
+	if found {
+		return
+	}
+	in.Input = movement.ClampInput(in.Input)
+	c.inputs = slices.Insert(c.inputs, i, in)
+	if len(c.inputs) > MaxInputBuffer {
+		// The client is sending faster than the ticks run, skip ahead rather than fall further behind.
+		c.inputs = slices.Delete(c.inputs, 0, len(c.inputs)-MaxInputBuffer)
+	}
+}
+
+// nextInput moves on to the next buffered command.
+// Once the buffer runs dry, no buttons are held until it fills back up, looking the same way as the last command.
+// Repeating the last command instead would apply it twice under the same sequence number, which the owner can't predict.
+func (c *Controller) nextInput() {
+	if len(c.inputs) == 0 || c.buffering && len(c.inputs) < InputBufferTicks {
+		c.buffering = true
+		c.Input.Buttons = 0
+		return
+	}
+	c.buffering = false
+	c.Input = c.inputs[0]
+	c.inputs = slices.Delete(c.inputs, 0, 1)
+}
+
+// characterSystem moves controlled entities by their input, with the movement the clients predict.
+func characterSystem(w *World, dt float64) {
+	step := float32(dt)
+	w.Controllers.Each(func(e Entity, c *Controller) {
+		c.nextInput()
+		c.Yaw, c.Pitch = c.Input.Yaw, c.Input.Pitch
+		t, v := w.Transforms.Get(e), w.Velocities.Get(e)
+		if t == nil || v == nil {
+			return
+		}
+
+		state := movement.State{Position: t.Position, Velocity: *v}
+		movement.Step(&state, c.Input.Input, &movement.DefaultLevel, step)
+		// Simulate on exactly what's replicated, so the owner's prediction rewinds to the same state.
+		t.Position, *v = protocol.Position.Round(state.Position), protocol.Velocity.Round(state.Velocity)
+
+		// Only the yaw turns the body, about +y.
+		sin, cos := math.Sincos(float64(-c.Yaw) / 2)
+		t.Rotation = [4]float32{0, float32(sin), 0, float32(cos)}
+	})
+}
+
+// movementSystem moves entities by their velocity, other than characters which move themselves.
+func movementSystem(w *World, dt float64) {
+	w.Velocities.Each(func(e Entity, v *Velocity) {
+		if w.Controllers.Get(e) != nil {
+			return
+		}
+		if t := w.Transforms.Get(e); t != nil {
+			for i := range 3 {
+				t.Position[i] += float32(float64(v[i]) * dt)
+			}
+		}
+	})
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"testing"
+
+	"webgl-multiplayer/movement"
+)
+
+// testInput is the input command with the sequence number, holding forward and looking in a direction of its own.
+func testInput(seq uint32) Input {
+	return Input{Seq: seq, Input: movement.Input{Buttons: movement.ButtonForward, Yaw: float32(seq) / 10, Pitch: -float32(seq) / 10}}
+}
+
+// applied runs n ticks of the controller, and returns the sequence numbers of the commands it applied.
+func applied(c *Controller, n int) []uint32 {
+	var seqs []uint32
+	for range n {
+		c.nextInput()
+		seqs = append(seqs, c.Input.Seq)
+	}
+	return seqs
+}
+
+func checkApplied(t *testing.T, c *Controller, want ...uint32) {
+	t.Helper()
+	got := applied(c, len(want))
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("applied commands %v, expected %v", got, want)
+		}
+	}
+}
+
+func TestInputInOrder(t *testing.T) {
+	c := &Controller{buffering: true}
+	c.queueInput(testInput(1))
+	checkApplied(t, c, 0) // still buffering
+	c.queueInput(testInput(2))
+	checkApplied(t, c, 1)
+	c.queueInput(testInput(3))
+	checkApplied(t, c, 2, 3)
+	if c.Input.Input != testInput(3).Input {
+		t.Fatalf("applied %+v, expected command 3", c.Input)
+	}
+
+	// Commands that arrive out of order are still applied in order.
+	c.queueInput(testInput(6))
+	c.queueInput(testInput(4))
+	c.queueInput(testInput(5))
+	checkApplied(t, c, 4, 5, 6)
+}
+
+// TestInputBufferEmpty checks nothing is held while waiting for commands, rather than repeating the last one.
+func TestInputBufferEmpty(t *testing.T) {
+	c := &Controller{buffering: true}
+	c.queueInput(testInput(1))
+	c.queueInput(testInput(2))
+	checkApplied(t, c, 1, 2)
+
+	for range 3 {
+		checkApplied(t, c, 2)
+		want := testInput(2).Input
+		want.Buttons = 0
+		if c.Input.Input != want {
+			t.Fatalf("applied %+v with an empty buffer, expected no buttons and command 2's look angles", c.Input.Input)
+		}
+	}
+
+	// It waits for the buffer to fill back up before applying commands again.
+	c.queueInput(testInput(3))
+	checkApplied(t, c, 2)
+	c.queueInput(testInput(4))
+	checkApplied(t, c, 3, 4)
+	if c.Input.Buttons != movement.ButtonForward {
+		t.Fatal("buttons weren't applied again once the buffer filled back up")
+	}
+}
+
+func TestInputDuplicate(t *testing.T) {
+	c := &Controller{buffering: true}
+	c.queueInput(testInput(1))
+	c.queueInput(testInput(1))
+	c.queueInput(testInput(2))
+	c.queueInput(testInput(2))
+	if len(c.inputs) != 2 {
+		t.Fatalf("buffered %d commands, expected the duplicates dropped", len(c.inputs))
+	}
+	checkApplied(t, c, 1)
+	c.queueInput(testInput(1)) // a duplicate of the applied command
+	c.queueInput(testInput(3))
+	checkApplied(t, c, 2, 3)
+}
+
+func TestInputStale(t *testing.T) {
+	c := &Controller{buffering: true}
+	c.queueInput(testInput(5))
+	c.queueInput(testInput(6))
+	checkApplied(t, c, 5)
+	c.queueInput(testInput(4))
+	c.queueInput(testInput(7))
+	checkApplied(t, c, 6, 7)
+	if len(c.inputs) != 0 {
+		t.Fatalf("commands %v older than the applied one are still buffered", c.inputs)
+	}
+
+	// Sequence numbers wrap around.
+	c = &Controller{Input: testInput(1<<32 - 1)}
+	c.queueInput(testInput(1<<32 - 2))
+	c.queueInput(testInput(0))
+	if len(c.inputs) != 1 || c.inputs[0].Seq != 0 {
+		t.Fatalf("buffered %v after command 2^32-1, expected only 0", c.inputs)
+	}
+}
+
+// TestInputOverflow checks the oldest commands are dropped once the buffer is full.
+func TestInputOverflow(t *testing.T) {
+	c := &Controller{buffering: true}
+	for seq := range uint32(MaxInputBuffer + 2) {
+		c.queueInput(testInput(seq + 1))
+	}
+	checkApplied(t, c, 3)
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"errors"
+	"math"
+)
+
+// MaxEntities is the number of entity slots in a world.
+const MaxEntities = 1 << 16
+
+var ErrTooManyEntities = errors.New("too many entities")
+
+// Entity identifies an entity in the world.
+// The low 16 bits are its slot, and the high 16 bits the slot's generation,
+// which is bumped whenever an entity in the slot is spawned or destroyed so stale IDs never match a new entity.
+// A slot is retired once its generation runs out rather than wrapping around, so that stays true however long the world runs.
+// The zero Entity is never alive.
+type Entity uint32
+
+func newEntity(index uint16, generation uint16) Entity {
+	return Entity(generation)<<16 | Entity(index)
+}
+
+func (e Entity) Index() uint16 {
+	return uint16(e)
+}
+
+func (e Entity) Generation() uint16 {
+	return uint16(e >> 16)
+}
+
+// System updates the world by one time step of dt seconds.
+type System func(w *World, dt float64)
+
+// World is the game's entity/component store.
+// Every component type has its own store, and systems run over them in the order they were added.
+// It's owned by the game loop, so nothing here is synchronized.
+type World struct {
+	generations []uint16 // by slot, odd while the slot is alive
+	free        []uint16 // slots of destroyed entities, reused first, retired slots excluded
+
+	Transforms  *Store[Transform]
+	Velocities  *Store[Velocity]
+	Models      *Store[Model]
+	Materials   *Store[Material]
+	Owners      *Store[Owner]
+	Controllers *Store[Controller]
+
+	stores  []store
+	systems []System
+}
+
+// store is the part of a component store the world needs to destroy entities.
+type store interface {
+	Remove(e Entity)
+}
+
+func NewWorld() *World {
+	w := &World{}
+	w.Transforms = addStore[Transform](w)
+	w.Velocities = addStore[Velocity](w)
+	w.Models = addStore[Model](w)
+	w.Materials = addStore[Material](w)
+	w.Owners = addStore[Owner](w)
+	w.Controllers = addStore[Controller](w)
+	return w
+}
+
+func addStore[T any](w *World) *Store[T] {
+	s := &Store[T]{}
+	w.stores = append(w.stores, s)
+	return s
+}
+
+// AddSystem appends a system to run each step, after the systems already added.
+func (w *World) AddSystem(s System) {
+	w.systems = append(w.systems, s)
+}
+
+// Step runs every system in order.
+func (w *World) Step(dt float64) {
+	for _, s := range w.systems {
+		s(w, dt)
+	}
+}
+
+// Spawn creates an entity with no components.
+func (w *World) Spawn() (Entity, error) {
+	var index uint16
+	if n := len(w.free); n > 0 {
+		index = w.free[n-1]
+		w.free = w.free[:n-1]
+	} else if len(w.generations) < MaxEntities {
+		index = uint16(len(w.generations))
+		w.generations = append(w.generations, 0)
+	} else {
+		return 0, ErrTooManyEntities
+	}
+	w.generations[index]++
+	return newEntity(index, w.generations[index]), nil
+}
+
+// Alive reports whether the entity was spawned and not destroyed since.
+func (w *World) Alive(e Entity) bool {
+	i := int(e.Index())
+	return i < len(w.generations) && w.generations[i] == e.Generation() && e.Generation()%2 == 1
+}
+
+// Destroy removes the entity and all of its components. Destroying a dead entity does nothing.
+func (w *World) Destroy(e Entity) {
+	if !w.Alive(e) {
+		return
+	}
+	for _, s := range w.stores {
+		s.Remove(e)
+	}
+	if e.Generation() == math.MaxUint16 {
+		// The slot's last generation, reusing it would wrap around to IDs that were already handed out.
+		// Its generation goes back to 0, which is never alive, and it's never spawned in again.
+		w.generations[e.Index()] = 0
+		return
+	}
+	// Skip the even generation, so the slot's generation is odd exactly while it's alive.
+	w.generations[e.Index()]++
+	w.free = append(w.free, e.Index())
+}
+
+// Store holds one type of component, packed densely so systems iterate over contiguous memory.
+// Pointers returned by the store are only valid until the next Add or Remove.
+type Store[T any] struct {
+	dense    []T
+	entities []Entity // by dense index
+	sparse   []int32  // dense index + 1 by entity slot, 0 if the slot has no component
+}
+
+// Add sets the entity's component, replacing any it already had.
+func (s *Store[T]) Add(e Entity, c T) *T {
+	i := int(e.Index())
+	if i >= len(s.sparse) {
+		s.sparse = append(s.sparse, make([]int32, i+1-len(s.sparse))...)
+	}
+	if d := s.sparse[i]; d != 0 {
+		s.dense[d-1] = c
+		s.entities[d-1] = e
+		return &s.dense[d-1]
+	}
+	s.dense = append(s.dense, c)
+	s.entities = append(s.entities, e)
+	s.sparse[i] = int32(len(s.dense))
+	return &s.dense[len(s.dense)-1]
+}
+
+// Get returns the entity's component, or nil if it doesn't have one.
+func (s *Store[T]) Get(e Entity) *T {
+	i := int(e.Index())
+	if i >= len(s.sparse) || s.sparse[i] == 0 {
+		return nil
+	}
+	d := s.sparse[i] - 1
+	if s.entities[d] != e {
+		return nil
+	}
+	return &s.dense[d]
+}
+
+// Remove deletes the entity's component, moving the last component into its place.
+func (s *Store[T]) Remove(e Entity) {
+	i := int(e.Index())
+	if i >= len(s.sparse) || s.sparse[i] == 0 || s.entities[s.sparse[i]-1] != e {
+		return
+	}
+	d := s.sparse[i] - 1
+	last := int32(len(s.dense) - 1)
+	if d != last {
+		s.dense[d] = s.dense[last]
+		s.entities[d] = s.entities[last]
+		s.sparse[s.entities[d].Index()] = d + 1
+	}
+	var zero T
+	s.dense[last] = zero
+	s.dense = s.dense[:last]
+	s.entities = s.entities[:last]
+	s.sparse[i] = 0
+}
+
+// Len is the number of entities with the component.
+func (s *Store[T]) Len() int {
+	return len(s.dense)
+}
+
+// Each calls f with every entity that has the component, in storage order.
+// F must not add or remove components of this type.
+func (s *Store[T]) Each(f func(e Entity, c *T)) {
+	for d := range s.dense {
+		f(s.entities[d], &s.dense[d])
+	}
+}
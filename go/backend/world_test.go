@@ -0,0 +1,123 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func spawn(t *testing.T, w *World) Entity {
+	t.Helper()
+	e, err := w.Spawn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return e
+}
+
+func TestStoreSwapRemove(t *testing.T) {
+	w := NewWorld()
+	var entities []Entity
+	for i := range 4 {
+		e := spawn(t, w)
+		w.Models.Add(e, Model{ID: ModelID(i)})
+		entities = append(entities, e)
+	}
+
+	// Removing from the middle moves the last component into the hole.
+	w.Models.Remove(entities[1])
+	if w.Models.Len() != 3 {
+		t.Fatalf("%d models after removing one of 4", w.Models.Len())
+	}
+	if w.Models.Get(entities[1]) != nil {
+		t.Fatal("removed model is still there")
+	}
+	for i, e := range entities {
+		if i == 1 {
+			continue
+		}
+		if m := w.Models.Get(e); m == nil || m.ID != ModelID(i) {
+			t.Fatalf("entity %d has model %+v after the swap, expected %d", i, m, i)
+		}
+	}
+	var order []ModelID
+	w.Models.Each(func(e Entity, m *Model) { order = append(order, m.ID) })
+	if len(order) != 3 || order[0] != 0 || order[1] != 3 || order[2] != 2 {
+		t.Fatalf("stored in order %v, expected the last moved into the hole: [0 3 2]", order)
+	}
+
+	// Removing the last one, or one that's already gone, leaves the others alone.
+	w.Models.Remove(entities[2])
+	w.Models.Remove(entities[1])
+	if w.Models.Len() != 2 || w.Models.Get(entities[0]).ID != 0 || w.Models.Get(entities[3]).ID != 3 {
+		t.Fatal("removing the last model or a removed one changed the others")
+	}
+}
+
+func TestDestroy(t *testing.T) {
+	w := NewWorld()
+	e := spawn(t, w)
+	w.Transforms.Add(e, Transform{})
+	w.Models.Add(e, Model{ID: 1})
+	if !w.Alive(e) {
+		t.Fatal("spawned entity isn't alive")
+	}
+	w.Destroy(e)
+	if w.Alive(e) {
+		t.Fatal("destroyed entity is alive")
+	}
+	if w.Transforms.Len() != 0 || w.Models.Len() != 0 {
+		t.Fatal("destroyed entity's components weren't removed")
+	}
+	w.Destroy(e) // a second destroy does nothing
+	if w.Alive(0) {
+		t.Fatal("the zero entity is alive")
+	}
+}
+
+func TestSlotReuse(t *testing.T) {
+	w := NewWorld()
+	e := spawn(t, w)
+	w.Models.Add(e, Model{ID: 1})
+	w.Destroy(e)
+
+	reused := spawn(t, w)
+	if reused.Index() != e.Index() || reused == e {
+		t.Fatalf("spawned %#x after destroying %#x, expected the same slot in a new generation", reused, e)
+	}
+	if w.Alive(e) || !w.Alive(reused) {
+		t.Fatal("the stale entity matches the one reusing its slot")
+	}
+	if w.Models.Get(reused) != nil {
+		t.Fatal("the new entity has the destroyed one's component")
+	}
+	w.Models.Add(reused, Model{ID: 2})
+	if w.Models.Get(e) != nil {
+		t.Fatal("the stale entity sees the new one's component")
+	}
+	w.Destroy(e)
+	if !w.Alive(reused) {
+		t.Fatal("destroying the stale entity destroyed the new one")
+	}
+}
+
+// TestSlotRetired checks a slot is never reused once its generation runs out, so it can't wrap around to old IDs.
+func TestSlotRetired(t *testing.T) {
+	w := NewWorld()
+	e := spawn(t, w)
+	w.Destroy(e)
+	w.generations[e.Index()] = math.MaxUint16 - 1
+
+	last := spawn(t, w)
+	if last.Index() != e.Index() || last.Generation() != math.MaxUint16 || !w.Alive(last) {
+		t.Fatalf("spawned %#x, expected the slot's last generation", last)
+	}
+	w.Destroy(last)
+	if w.Alive(last) || w.Alive(e) {
+		t.Fatal("an entity of the retired slot is alive")
+	}
+	for range 3 {
+		if next := spawn(t, w); next.Index() == e.Index() {
+			t.Fatalf("spawned %#x in the retired slot", next)
+		}
+	}
+}
@@ -0,0 +1,445 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// writer builds a generated file.
+type writer struct {
+	bytes.Buffer
+}
+
+func (w *writer) p(format string, args ...any) {
+	fmt.Fprintf(w, format, args...)
+	w.WriteByte('\n')
+}
+
+// doc writes a doc comment, one line of comment per line of text.
+func (w *writer) doc(indent string, text string) {
+	for _, line := range strings.Split(text, "\n") {
+		w.p("%s// %s", indent, line)
+	}
+}
+
+// generateGo generates the protocol package's message types.
+func generateGo(s *Schema, source string) ([]byte, error) {
+	w := &writer{}
+	w.p("// Code generated by protogen from %s. DO NOT EDIT.", source)
+	w.p("")
+	w.p("package protocol")
+	w.p("")
+	w.p("import (")
+	w.p(`"encoding/binary"`)
+	if len(s.Quantizers) > 0 {
+		w.p("")
+		w.p(`"webgl-multiplayer/quantize"`)
+	}
+	w.p(")")
+	w.p("")
+
+	for _, c := range s.Constants {
+		w.doc("", c.Doc)
+		w.p("const %s = %d", c.Name, c.Value)
+		w.p("")
+	}
+
+	w.p("// Message types, server messages below 0x80 and client messages from it.")
+	w.p("// Event messages are server messages only ever sent inside a union field, like Event's.")
+	w.p("const (")
+	for _, m := range s.Messages {
+		if m.Direction == "client" {
+			w.p("Msg%s byte = %#x", m.Name, m.ID)
+		} else {
+			w.p("Msg%s byte = %d", m.Name, m.ID)
+		}
+	}
+	w.p(")")
+	w.p("")
+	if len(s.Quantizers) > 0 {
+		w.p("// Quantizers of the quantized fields.")
+		w.p("var (")
+		for _, q := range s.Quantizers {
+			w.doc("", fmt.Sprintf("%s Packed in %d bytes.", q.Doc, q.size()))
+			if q.Bits > 0 {
+				w.p("%s = quantize.Rotation{Bits: %d}", q.Name, q.Bits)
+				continue
+			}
+			w.p("%s = quantize.Vector{", q.Name)
+			for _, r := range q.vector {
+				w.p("{Min: %s, Max: %s, Precision: %s},", goFloat(r.Min), goFloat(r.Max), goPrecision(r.Precision))
+			}
+			w.p("}")
+		}
+		w.p(")")
+		w.p("")
+		w.p("// Encoded sizes of the quantized fields.")
+		w.p("const (")
+		for _, q := range s.Quantizers {
+			w.p("%s = %d", sizeConst(q), q.size())
+		}
+		w.p(")")
+		w.p("")
+	}
+
+	for _, t := range s.all() {
+		if flags := t.flags(); flags != nil {
+			w.p("// %s fields, set in its %s mask when they're encoded.", t.Name, flags.Name)
+			w.p("const (")
+			var all []string
+			for i, f := range t.optional() {
+				if i == 0 {
+					w.p("%s uint8 = 1 << iota", f.Flag)
+				} else {
+					w.p("%s", f.Flag)
+				}
+				all = append(all, f.Flag)
+			}
+			w.p("")
+			w.p("%s = %s", flags.All, strings.Join(all, " | "))
+			w.p(")")
+			w.p("")
+		}
+		goStruct(w, t)
+	}
+
+	for _, direction := range []string{"server", "client"} {
+		name := "new" + strings.ToUpper(direction[:1]) + direction[1:] + "Message"
+		w.p("// %s returns an empty %s message of the given type, nil if there's no such message.", name, direction)
+		w.p("func %s(t byte) Message {", name)
+		w.p("switch t {")
+		for _, m := range s.direction(direction) {
+			w.p("case Msg%s:", m.Name)
+			w.p("return &%s{}", m.Name)
+		}
+		w.p("}")
+		w.p("return nil")
+		w.p("}")
+		w.p("")
+	}
+	return formatGo(w.Bytes())
+}
+
+// generateGoJSON generates the part of the message types only the JSON debug wire format needs,
+// built with the jsonwire tag so encoding/json stays out of other builds.
+func generateGoJSON(s *Schema, source string) ([]byte, error) {
+	w := &writer{}
+	w.p("// Code generated by protogen from %s. DO NOT EDIT.", source)
+	w.p("")
+	w.p("//go:build jsonwire")
+	w.p("")
+	w.p("package protocol")
+	w.p("")
+	if s.hasUnion() {
+		w.p(`import "encoding/json"`)
+		w.p("")
+	}
+
+	w.p("// messageNames are the names of the message types, as they're written in JSON.")
+	w.p("var messageNames = map[byte]string{")
+	for _, m := range s.Messages {
+		w.p("Msg%s: %q,", m.Name, m.Name)
+	}
+	w.p("}")
+	w.p("")
+	w.p("// newMessage returns an empty message of the given type, events included, nil if there's no such message.")
+	w.p("func newMessage(t byte) Message {")
+	w.p("switch t {")
+	for _, m := range s.Messages {
+		w.p("case Msg%s:", m.Name)
+		w.p("return &%s{}", m.Name)
+	}
+	w.p("}")
+	w.p("return nil")
+	w.p("}")
+	w.p("")
+
+	for _, t := range s.all() {
+		if union := t.union(); union != nil {
+			goUnionJSON(w, t, union)
+		}
+	}
+	return formatGo(w.Bytes())
+}
+
+func goStruct(w *writer, t *Struct) {
+	w.doc("", t.Doc)
+	w.p("//")
+	for _, f := range t.Fields {
+		w.p("//   - %s", f.layout())
+	}
+	w.p("type %s struct {", t.Name)
+	for _, f := range t.Fields {
+		w.p("%s %s `json:\"%s\"`", f.Name, goType(f), tsName(f.Name))
+	}
+	w.p("}")
+	w.p("")
+
+	size, appendName := "size", "append"
+	if t.message {
+		size, appendName = "Size", "Append"
+		w.p("func (m *%s) Type() byte { return Msg%s }", t.Name, t.Name)
+	}
+	if n, ok := t.fixedSize(); ok {
+		w.p("func (m *%s) %s() int { return %d }", t.Name, size, n)
+	} else {
+		w.p("")
+		w.p("func (m *%s) %s() int {", t.Name, size)
+		goSize(w, t)
+		w.p("}")
+	}
+	w.p("")
+
+	w.p("func (m *%s) %s(b []byte) []byte {", t.Name, appendName)
+	for _, f := range t.Fields {
+		if f.Flag != "" {
+			w.p("if m.%s&%s != 0 {", t.flags().Name, f.Flag)
+		}
+		goAppend(w, f)
+		if f.Flag != "" {
+			w.p("}")
+		}
+	}
+	w.p("return b")
+	w.p("}")
+	w.p("")
+
+	if t.message {
+		w.p("func (m *%s) MarshalBinary() ([]byte, error) {", t.Name)
+		w.p("return m.Append(make([]byte, 0, m.Size())), nil")
+		w.p("}")
+		w.p("")
+		w.p("func (m *%s) UnmarshalBinary(body []byte) error {", t.Name)
+		w.p("r := reader{data: body}")
+		w.p("m.read(&r)")
+		w.p("return r.done()")
+		w.p("}")
+		w.p("")
+	}
+
+	w.p("func (m *%s) read(r *reader) {", t.Name)
+	declared := false
+	for _, f := range t.Fields {
+		if f.Flag != "" {
+			w.p("if m.%s&%s != 0 {", t.flags().Name, f.Flag)
+		}
+		if goRead(w, f, declared) {
+			declared = true
+		}
+		if f.Flag != "" {
+			w.p("}")
+		}
+	}
+	w.p("}")
+	w.p("")
+}
+
+// goUnionJSON writes the JSON methods of a struct with a union field,
+// which is written like a message in JSON so it can be decoded as the right type.
+func goUnionJSON(w *writer, t *Struct, f *Field) {
+	var types []string
+	for _, m := range f.of {
+		types = append(types, "Msg"+m.Name)
+	}
+	w.p("func (m *%s) MarshalJSON() ([]byte, error) {", t.Name)
+	w.p("union, err := marshalUnion(m.%s)", f.Name)
+	w.p("if err != nil {")
+	w.p("return nil, err")
+	w.p("}")
+	w.p("type fields %s", t.Name)
+	w.p("return json.Marshal(struct {")
+	w.p("*fields")
+	w.p("%s json.RawMessage `json:\"%s\"`", f.Name, tsName(f.Name))
+	w.p("}{(*fields)(m), union})")
+	w.p("}")
+	w.p("")
+	w.p("func (m *%s) UnmarshalJSON(data []byte) error {", t.Name)
+	w.p("type fields %s", t.Name)
+	w.p("v := struct {")
+	w.p("*fields")
+	w.p("%s json.RawMessage `json:\"%s\"`", f.Name, tsName(f.Name))
+	w.p("}{fields: (*fields)(m)}")
+	w.p("if err := unmarshalStrict(data, &v); err != nil {")
+	w.p("return err")
+	w.p("}")
+	w.p("var err error")
+	w.p("m.%s, err = unmarshalUnion(v.%s, %s)", f.Name, f.Name, strings.Join(types, ", "))
+	w.p("return err")
+	w.p("}")
+	w.p("")
+}
+
+func goType(f *Field) string {
+	switch f.Type {
+	case "flags":
+		return "uint8"
+	case "bytes":
+		if f.Length != strconv.Itoa(f.length) {
+			return "[" + f.Length + "]byte"
+		}
+		return fmt.Sprintf("[%d]byte", f.length)
+	case "quantized":
+		return fmt.Sprintf("[%d]float32", f.quantizer.components())
+	case "list":
+		return "[]" + f.Elem
+	case "union":
+		return "Message"
+	}
+	return f.Type
+}
+
+func goSize(w *writer, t *Struct) {
+	fixed := 0
+	for _, f := range t.Fields {
+		if f.Flag == "" && f.Type != "string" {
+			fixed += f.minSize()
+		}
+	}
+	w.p("size := %d", fixed)
+	for _, f := range t.Fields {
+		switch {
+		case f.Flag != "":
+			n, _ := f.fixedSize()
+			w.p("if m.%s&%s != 0 {", t.flags().Name, f.Flag)
+			w.p("size += %d", n)
+			w.p("}")
+		case f.Type == "string":
+			w.p("size += stringSize(m.%s)", f.Name)
+		case f.Type == "list" && f.elem == nil:
+			w.p("size += len(m.%s) * %d", f.Name, fixedSizes[f.Elem])
+		case f.Type == "list":
+			if n, ok := f.elem.fixedSize(); ok {
+				w.p("size += len(m.%s) * %d", f.Name, n)
+			} else {
+				w.p("for i := range m.%s {", f.Name)
+				w.p("size += m.%s[i].size()", f.Name)
+				w.p("}")
+			}
+		case f.Type == "union":
+			w.p("size += m.%s.Size()", f.Name)
+		}
+	}
+	w.p("return size")
+}
+
+// goAppendValue returns the statement appending a number to b.
+func goAppendValue(typ string, v string) string {
+	switch typ {
+	case "uint8", "flags":
+		return fmt.Sprintf("b = append(b, %s)", v)
+	case "uint16":
+		return fmt.Sprintf("b = binary.LittleEndian.AppendUint16(b, %s)", v)
+	case "uint32":
+		return fmt.Sprintf("b = binary.LittleEndian.AppendUint32(b, %s)", v)
+	case "float32":
+		return fmt.Sprintf("b = appendFloat32s(b, %s)", v)
+	case "float64":
+		return fmt.Sprintf("b = appendFloat64(b, %s)", v)
+	case "bool":
+		return fmt.Sprintf("b = appendBool(b, %s)", v)
+	}
+	panic("not a number type: " + typ)
+}
+
+func goAppend(w *writer, f *Field) {
+	v := "m." + f.Name
+	switch f.Type {
+	case "string":
+		w.p("b = appendString(b, %s)", v)
+	case "bytes":
+		w.p("b = append(b, %s[:]...)", v)
+	case "quantized":
+		w.p("b = appendPacked(b, %s.Pack(%s), %s)", f.Quantizer, v, sizeConst(f.quantizer))
+	case "list":
+		w.p("b = binary.LittleEndian.AppendUint16(b, uint16(len(%s)))", v)
+		if f.elem != nil {
+			w.p("for i := range %s {", v)
+			w.p("b = %s[i].append(b)", v)
+		} else {
+			w.p("for _, v := range %s {", v)
+			w.p("%s", goAppendValue(f.Elem, "v"))
+		}
+		w.p("}")
+	case "union":
+		w.p("b = append(b, %s.Type())", v)
+		w.p("b = %s.Append(b)", v)
+	default:
+		w.p("%s", goAppendValue(f.Type, v))
+	}
+}
+
+// goRead writes the statements reading a field, returning true if they declared the list length variables.
+func goRead(w *writer, f *Field, declared bool) bool {
+	v := "m." + f.Name
+	switch f.Type {
+	case "flags":
+		w.p("%s = r.uint8()", v)
+		w.p("if %s&^%s != 0 {", v, f.All)
+		w.p("r.fail(ErrInvalidField)")
+		w.p("}")
+	case "bytes":
+		w.p("copy(%s[:], r.take(len(%s)))", v, v)
+	case "quantized":
+		w.p("%s = %s.Unpack(r.packed(%s))", v, f.Quantizer, sizeConst(f.quantizer))
+	case "list":
+		assign := ":="
+		if declared {
+			assign = "="
+		}
+		w.p("n, capacity %s r.count(%d)", assign, f.elemSize())
+		w.p("%s = make(%s, 0, capacity)", v, goType(f))
+		w.p("for i := 0; i < n && r.err == nil; i++ {")
+		if f.elem != nil {
+			w.p("var e %s", f.Elem)
+			w.p("e.read(r)")
+			w.p("%s = append(%s, e)", v, v)
+		} else {
+			w.p("%s = append(%s, r.%s())", v, v, f.Elem)
+		}
+		w.p("}")
+		return true
+	case "union":
+		w.p("switch r.uint8() {")
+		for _, m := range f.of {
+			w.p("case Msg%s:", m.Name)
+			w.p("%s = &%s{}", v, m.Name)
+		}
+		w.p("default:")
+		w.p("r.fail(ErrUnknownMessage)")
+		w.p("return")
+		w.p("}")
+		w.p("r.fail(%s.UnmarshalBinary(r.rest()))", v)
+	default:
+		w.p("%s = r.%s()", v, f.Type)
+	}
+	return declared
+}
+
+// sizeConst is the name of the constant holding a quantizer's encoded size.
+func sizeConst(q *Quantizer) string {
+	return strings.ToLower(q.Name[:1]) + q.Name[1:] + "Size"
+}
+
+func goFloat(f float32) string {
+	return strconv.FormatFloat(float64(f), 'g', -1, 32)
+}
+
+// goPrecision writes precisions that are fractions like 1/1024 as such.
+func goPrecision(f float32) string {
+	if inv := 1 / float64(f); inv > 1 && inv == math.Trunc(inv) {
+		return fmt.Sprintf("1.0 / %d", int(inv))
+	}
+	return goFloat(f)
+}
+
+func formatGo(src []byte) ([]byte, error) {
+	formatted, err := format.Source(src)
+	if err != nil {
+		return nil, fmt.Errorf("generated invalid go: %w\n%s", err, src)
+	}
+	return formatted, nil
+}
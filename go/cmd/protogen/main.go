@@ -0,0 +1,59 @@
+// Command protogen generates the protocol package's message types from a declarative schema,
+// along with golden tests for them and a TypeScript decoder for the frontend.
+//
+// It runs from go generate in the protocol package:
+//
+//	go generate ./protocol
+//	go test ./protocol -update # after changing the wire format on purpose
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+func main() {
+	schemaPath := flag.String("schema", "schema.json", "schema of the message types")
+	goOut := flag.String("go", "messages_gen.go", "go file to generate the message types in")
+	jsonOut := flag.String("json", "messages_json_gen.go", "go file to generate the JSON methods of the message types in, built with the jsonwire tag")
+	testOut := flag.String("test", "messages_gen_test.go", "go file to generate the golden tests in, none if empty")
+	tsOut := flag.String("ts", "", "TypeScript file to generate the decoder in, none if empty")
+	flag.Parse()
+	log.SetFlags(0)
+	log.SetPrefix("protogen: ")
+
+	s, err := loadSchema(*schemaPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	source := filepath.Base(*schemaPath)
+
+	src, err := generateGo(s, source)
+	if err != nil {
+		log.Fatal(err)
+	}
+	write(*goOut, src)
+	src, err = generateGoJSON(s, source)
+	if err != nil {
+		log.Fatal(err)
+	}
+	write(*jsonOut, src)
+	if *testOut != "" {
+		src, err := generateGoTest(s, source)
+		if err != nil {
+			log.Fatal(err)
+		}
+		write(*testOut, src)
+	}
+	if *tsOut != "" {
+		write(*tsOut, generateTypeScript(s, source))
+	}
+}
+
+func write(path string, data []byte) {
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		log.Fatal(err)
+	}
+}
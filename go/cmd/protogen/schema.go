@@ -0,0 +1,403 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"webgl-multiplayer/quantize"
+)
+
+// Schema is the declarative description of the wire format that the protocol package and the frontend decoder are generated from.
+type Schema struct {
+	Constants  []*Constant  `json:"constants"`
+	Quantizers []*Quantizer `json:"quantizers"`
+	Structs    []*Struct    `json:"structs"`
+	Messages   []*Struct    `json:"messages"`
+
+	constants  map[string]*Constant
+	quantizers map[string]*Quantizer
+	types      map[string]*Struct // structs and messages by name
+}
+
+// Constant is a named integer shared by the schema and the generated code.
+type Constant struct {
+	Name  string `json:"name"`
+	Value int    `json:"value"`
+	Doc   string `json:"doc"`
+}
+
+// Quantizer packs floats into fewer bits, either a vector with a range per axis or a rotation with Bits per component.
+type Quantizer struct {
+	Name   string `json:"name"`
+	Doc    string `json:"doc"`
+	Ranges []struct {
+		Min       float32 `json:"min"`
+		Max       float32 `json:"max"`
+		Precision float32 `json:"precision"`
+	} `json:"ranges"`
+	Bits int `json:"bits"`
+
+	vector   quantize.Vector
+	rotation quantize.Rotation
+}
+
+// Struct is a message, or a struct encoded as part of one.
+type Struct struct {
+	Name      string   `json:"name"`
+	ID        int      `json:"id"`        // message type, messages only
+	Direction string   `json:"direction"` // server, client or event, messages only
+	Doc       string   `json:"doc"`
+	Fields    []*Field `json:"fields"`
+
+	message bool
+}
+
+// Field is a field of a struct, encoded in the order they're declared.
+//
+// Types are uint8, uint16, uint32, float32, float64, bool, string (prefixed by its length in a single byte),
+// bytes (Length bytes, a number or a constant), quantized (packed by Quantizer), list (Elem, a struct or a number type,
+// prefixed by its length as a uint16), flags (a uint8 mask of the optional fields, All names the mask of all of them),
+// and union (one of the event messages in Of, prefixed by its message type, always the last field).
+// Fields with a Flag are optional, only encoded if their flag is set in the struct's flags field.
+type Field struct {
+	Name      string   `json:"name"`
+	Type      string   `json:"type"`
+	Doc       string   `json:"doc"`
+	Length    string   `json:"length"`
+	Quantizer string   `json:"quantizer"`
+	Elem      string   `json:"elem"`
+	Of        []string `json:"of"`
+	Flag      string   `json:"flag"`
+	All       string   `json:"all"`
+
+	length    int
+	quantizer *Quantizer
+	elem      *Struct // nil for lists of numbers
+	of        []*Struct
+}
+
+// fixedSizes are the encoded sizes of the number types.
+var fixedSizes = map[string]int{
+	"uint8":   1,
+	"uint16":  2,
+	"uint32":  4,
+	"float32": 4,
+	"float64": 8,
+	"bool":    1,
+	"flags":   1,
+}
+
+// loadSchema reads and checks a schema.
+func loadSchema(path string) (*Schema, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	s := &Schema{}
+	if err := json.Unmarshal(data, s); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if err := s.resolve(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return s, nil
+}
+
+// resolve links every reference in the schema, failing on anything that can't be generated.
+func (s *Schema) resolve() error {
+	s.constants = make(map[string]*Constant)
+	s.quantizers = make(map[string]*Quantizer)
+	s.types = make(map[string]*Struct)
+	for _, c := range s.Constants {
+		if s.constants[c.Name] != nil {
+			return fmt.Errorf("duplicate constant %s", c.Name)
+		}
+		s.constants[c.Name] = c
+	}
+	for _, q := range s.Quantizers {
+		if s.quantizers[q.Name] != nil {
+			return fmt.Errorf("duplicate quantizer %s", q.Name)
+		}
+		switch {
+		case len(q.Ranges) == 3 && q.Bits == 0:
+			for i, r := range q.Ranges {
+				q.vector[i] = quantize.Range{Min: r.Min, Max: r.Max, Precision: r.Precision}
+				if r.Precision <= 0 || r.Max <= r.Min || q.vector[i].Bits() > 32 {
+					return fmt.Errorf("quantizer %s: invalid range %d", q.Name, i)
+				}
+			}
+			if q.vector.Bits() > 64 {
+				return fmt.Errorf("quantizer %s: takes %d bits, more than 64", q.Name, q.vector.Bits())
+			}
+		case len(q.Ranges) == 0 && q.Bits >= 2 && q.Bits <= 20:
+			q.rotation = quantize.Rotation{Bits: q.Bits}
+		default:
+			return fmt.Errorf("quantizer %s: needs either 3 ranges or between 2 and 20 bits", q.Name)
+		}
+		s.quantizers[q.Name] = q
+	}
+
+	ids := make(map[int]string)
+	for _, m := range s.Messages {
+		m.message = true
+		switch m.Direction {
+		case "server", "event":
+			if m.ID < 1 || m.ID >= 0x80 {
+				return fmt.Errorf("message %s: server message types are between 1 and 127", m.Name)
+			}
+		case "client":
+			if m.ID < 0x80 || m.ID > 0xff {
+				return fmt.Errorf("message %s: client message types are between 128 and 255", m.Name)
+			}
+		default:
+			return fmt.Errorf("message %s: direction must be server, client or event", m.Name)
+		}
+		if other, ok := ids[m.ID]; ok {
+			return fmt.Errorf("messages %s and %s have the same type %d", other, m.Name, m.ID)
+		}
+		ids[m.ID] = m.Name
+	}
+	for _, t := range s.all() {
+		if s.types[t.Name] != nil {
+			return fmt.Errorf("duplicate type %s", t.Name)
+		}
+		s.types[t.Name] = t
+	}
+	for _, t := range s.all() {
+		if err := s.resolveFields(t); err != nil {
+			return fmt.Errorf("%s: %w", t.Name, err)
+		}
+	}
+	return nil
+}
+
+func (s *Schema) resolveFields(t *Struct) error {
+	var flags *Field
+	for i, f := range t.Fields {
+		if f.Flag != "" {
+			if flags == nil {
+				return fmt.Errorf("field %s: optional before the flags field", f.Name)
+			}
+			if f.Type == "flags" || f.Type == "union" {
+				return fmt.Errorf("field %s: %s fields can't be optional", f.Name, f.Type)
+			}
+		}
+		switch f.Type {
+		case "uint8", "uint16", "uint32", "float32", "float64", "bool", "string":
+		case "flags":
+			if flags != nil {
+				return fmt.Errorf("field %s: a struct has a single flags field", f.Name)
+			}
+			if f.All == "" {
+				return fmt.Errorf("field %s: flags need a name for the mask of all flags", f.Name)
+			}
+			flags = f
+		case "bytes":
+			if c := s.constants[f.Length]; c != nil {
+				f.length = c.Value
+			} else if n, err := strconv.Atoi(f.Length); err == nil {
+				f.length = n
+			}
+			if f.length <= 0 {
+				return fmt.Errorf("field %s: invalid length %q", f.Name, f.Length)
+			}
+		case "quantized":
+			if f.quantizer = s.quantizers[f.Quantizer]; f.quantizer == nil {
+				return fmt.Errorf("field %s: unknown quantizer %q", f.Name, f.Quantizer)
+			}
+		case "list":
+			if _, ok := fixedSizes[f.Elem]; ok && f.Elem != "bool" && f.Elem != "flags" {
+				break
+			}
+			if f.elem = s.types[f.Elem]; f.elem == nil || f.elem.message {
+				return fmt.Errorf("field %s: lists are of structs or numbers, not %q", f.Name, f.Elem)
+			}
+		case "union":
+			if i != len(t.Fields)-1 {
+				return fmt.Errorf("field %s: a union must be the last field", f.Name)
+			}
+			for _, name := range f.Of {
+				m := s.types[name]
+				if m == nil || m.Direction != "event" {
+					return fmt.Errorf("field %s: %q isn't an event message", f.Name, name)
+				}
+				f.of = append(f.of, m)
+			}
+		default:
+			return fmt.Errorf("field %s: unknown type %q", f.Name, f.Type)
+		}
+	}
+	if n := len(t.optional()); n > 8 {
+		return fmt.Errorf("%d optional fields don't fit in a flags field", n)
+	}
+	return nil
+}
+
+// all returns the structs and then the messages.
+func (s *Schema) all() []*Struct {
+	return append(append([]*Struct(nil), s.Structs...), s.Messages...)
+}
+
+// direction returns the messages sent in a direction, in the order they're declared.
+func (s *Schema) direction(direction string) []*Struct {
+	var messages []*Struct
+	for _, m := range s.Messages {
+		if m.Direction == direction {
+			messages = append(messages, m)
+		}
+	}
+	return messages
+}
+
+// flags returns the struct's flags field, nil if it has none.
+func (t *Struct) flags() *Field {
+	for _, f := range t.Fields {
+		if f.Type == "flags" {
+			return f
+		}
+	}
+	return nil
+}
+
+// union returns the struct's union field, nil if it has none.
+func (t *Struct) union() *Field {
+	for _, f := range t.Fields {
+		if f.Type == "union" {
+			return f
+		}
+	}
+	return nil
+}
+
+// hasUnion returns whether any struct has a union field.
+func (s *Schema) hasUnion() bool {
+	for _, t := range s.all() {
+		if t.union() != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// optional returns the fields that are only encoded if their flag is set.
+func (t *Struct) optional() []*Field {
+	var fields []*Field
+	for _, f := range t.Fields {
+		if f.Flag != "" {
+			fields = append(fields, f)
+		}
+	}
+	return fields
+}
+
+// fixedSize returns the encoded size of a field, or false if it depends on the field's value.
+func (f *Field) fixedSize() (int, bool) {
+	if n, ok := fixedSizes[f.Type]; ok {
+		return n, true
+	}
+	switch f.Type {
+	case "bytes":
+		return f.length, true
+	case "quantized":
+		return f.quantizer.size(), true
+	}
+	return 0, false
+}
+
+// minSize is the smallest a field can be encoded in.
+func (f *Field) minSize() int {
+	if f.Flag != "" {
+		return 0
+	}
+	if n, ok := f.fixedSize(); ok {
+		return n
+	}
+	switch f.Type {
+	case "string":
+		return 1
+	case "list":
+		return 2
+	case "union":
+		return 1
+	}
+	return 0
+}
+
+// fixedSize returns the encoded size of a struct, or false if it depends on its values.
+func (t *Struct) fixedSize() (int, bool) {
+	size := 0
+	for _, f := range t.Fields {
+		n, ok := f.fixedSize()
+		if !ok || f.Flag != "" {
+			return 0, false
+		}
+		size += n
+	}
+	return size, true
+}
+
+// minSize is the smallest a struct can be encoded in.
+func (t *Struct) minSize() int {
+	size := 0
+	for _, f := range t.Fields {
+		size += f.minSize()
+	}
+	return size
+}
+
+// elemSize is the smallest a list's element can be encoded in, at least a byte
+// so that a list's claimed length is always bounded by the bytes left.
+func (f *Field) elemSize() int {
+	if f.elem != nil {
+		return max(f.elem.minSize(), 1)
+	}
+	return fixedSizes[f.Elem]
+}
+
+func (q *Quantizer) size() int {
+	if q.Bits > 0 {
+		return q.rotation.Size()
+	}
+	return q.vector.Size()
+}
+
+func (q *Quantizer) components() int {
+	if q.Bits > 0 {
+		return 4
+	}
+	return 3
+}
+
+// layout describes how a field is encoded, for the doc comment of its struct.
+func (f *Field) layout() string {
+	var line string
+	switch f.Type {
+	case "uint8", "flags":
+		line = "1 byte: " + f.Doc
+	case "uint16", "uint32", "float32", "float64":
+		line = fmt.Sprintf("%d bytes: %s (%s)", fixedSizes[f.Type], f.Doc, f.Type)
+	case "bool":
+		line = "1 byte: " + f.Doc + ", 1 or 0"
+	case "string":
+		line = "1 byte: length, followed by the " + f.Doc + " (utf-8)"
+	case "bytes":
+		line = fmt.Sprintf("%d bytes: %s", f.length, f.Doc)
+	case "quantized":
+		line = fmt.Sprintf("%d bytes: %s quantized to %s", f.quantizer.size(), f.Doc, f.quantizer.Name)
+	case "list":
+		elem := f.Elem
+		if f.elem == nil {
+			elem = fmt.Sprintf("%d bytes (%s)", fixedSizes[f.Elem], f.Elem)
+		}
+		line = fmt.Sprintf("2 bytes: number of %s (uint16), followed by each of them, %s", f.Doc, elem)
+	case "union":
+		line = "1 byte: event type, followed by " + f.Doc + ", one of " + strings.Join(f.Of, ", ")
+	}
+	if f.Flag != "" {
+		line = f.Flag + ", " + line
+	}
+	return line
+}
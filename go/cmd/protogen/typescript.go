@@ -0,0 +1,353 @@
+package main
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// generateTypeScript generates a decoder of server messages for the frontend.
+// The wasm client decodes everything the game needs, this is for the main thread to read a message directly.
+// Only the server messages and the structs they're made of are generated, the frontend never encodes messages.
+func generateTypeScript(s *Schema, source string) []byte {
+	w := &writer{}
+	w.p("// Code generated by protogen from %s. DO NOT EDIT.", source)
+	w.p("")
+	w.p("// Decoder of the messages the server sends, matching the go protocol package.")
+	w.p("")
+
+	for _, c := range s.Constants {
+		w.tsDoc("", c.Doc)
+		w.p("export const %s = %d;", c.Name, c.Value)
+	}
+	w.p("")
+	w.p("// server message types, event messages are only sent inside a union field like Event's")
+	for _, m := range s.Messages {
+		if m.Direction != "client" {
+			w.p("export const Msg%s = %d;", m.Name, m.ID)
+		}
+	}
+	w.p("")
+	for _, t := range s.all() {
+		if flags := t.flags(); flags != nil {
+			w.p("// %s fields, set in its %s mask when they're encoded", t.Name, tsName(flags.Name))
+			var all []string
+			for i, f := range t.optional() {
+				w.p("export const %s = %d;", f.Flag, 1<<i)
+				all = append(all, f.Flag)
+			}
+			w.p("export const %s = %s;", flags.All, strings.Join(all, " | "))
+			w.p("")
+		}
+	}
+
+	w.p(tsRuntime)
+	w.p("")
+	for _, q := range s.Quantizers {
+		w.tsDoc("", q.Doc)
+		if q.Bits > 0 {
+			w.p("export const %s = new RotationQuantizer(%d);", q.Name, q.Bits)
+		} else {
+			w.p("export const %s = new VectorQuantizer([", q.Name)
+			for _, r := range q.vector {
+				w.p("\tnew Range(%s, %s, %s),", tsFloat(r.Min), tsFloat(r.Max), tsFloat(r.Precision))
+			}
+			w.p("]);")
+		}
+	}
+	w.p("")
+
+	var decoded []*Struct
+	for _, t := range s.all() {
+		if !t.message || t.Direction != "client" {
+			decoded = append(decoded, t)
+		}
+	}
+	for _, t := range decoded {
+		tsStruct(w, t)
+	}
+
+	var server []string
+	for _, m := range s.direction("server") {
+		server = append(server, m.Name)
+	}
+	w.p("export type ServerMessage = %s;", strings.Join(server, " | "))
+	w.p("")
+	w.p("/**")
+	w.p(" * Decodes a server message along with the tick it was sent on, throwing a ProtocolError if it's invalid")
+	w.p(" */")
+	w.p("export function decode(data: ArrayBuffer | Uint8Array): { tick: number; message: ServerMessage } {")
+	w.p("\tconst r = new Reader(data instanceof Uint8Array ? data : new Uint8Array(data));")
+	w.p("\tconst type = r.uint8();")
+	w.p("\tconst tick = r.uint32();")
+	w.p("\tlet message: ServerMessage;")
+	w.p("\tswitch (type) {")
+	for _, name := range server {
+		w.p("\t\tcase Msg%s:", name)
+		w.p("\t\t\tmessage = read%s(r);", name)
+		w.p("\t\t\tbreak;")
+	}
+	w.p("\t\tdefault:")
+	w.p("\t\t\tthrow new ProtocolError(\"unknown message type\");")
+	w.p("\t}")
+	w.p("\tr.done();")
+	w.p("\treturn { tick, message };")
+	w.p("}")
+	return w.Bytes()
+}
+
+func (w *writer) tsDoc(indent string, text string) {
+	if !strings.Contains(text, "\n") {
+		w.p("%s/** %s */", indent, text)
+		return
+	}
+	w.p("%s/**", indent)
+	for _, line := range strings.Split(text, "\n") {
+		w.p("%s * %s", indent, line)
+	}
+	w.p("%s */", indent)
+}
+
+func tsStruct(w *writer, t *Struct) {
+	w.tsDoc("", t.Doc)
+	w.p("export interface %s {", t.Name)
+	if t.message {
+		w.p("\ttype: typeof Msg%s;", t.Name)
+	}
+	for _, f := range t.Fields {
+		optional := ""
+		if f.Flag != "" {
+			optional = "?"
+		}
+		w.p("\t%s%s: %s;", tsName(f.Name), optional, tsType(f))
+	}
+	w.p("}")
+	w.p("")
+
+	w.p("function read%s(r: Reader): %s {", t.Name, t.Name)
+	if t.message {
+		w.p("\tconst m = { type: Msg%s } as %s;", t.Name, t.Name)
+	} else {
+		w.p("\tconst m = {} as %s;", t.Name)
+	}
+	for _, f := range t.Fields {
+		v := "m." + tsName(f.Name)
+		indent := "\t"
+		if f.Flag != "" {
+			w.p("\tif ((m.%s & %s) !== 0) {", tsName(t.flags().Name), f.Flag)
+			indent = "\t\t"
+		}
+		switch f.Type {
+		case "flags":
+			w.p("%s%s = r.uint8();", indent, v)
+			w.p("%sif ((%s & ~%s) !== 0) throw new ProtocolError(\"invalid field\");", indent, v, f.All)
+		case "bytes":
+			w.p("%s%s = r.bytes(%d);", indent, v, f.length)
+		case "quantized":
+			w.p("%s%s = %s.unpack(r.packed(%d));", indent, v, f.Quantizer, f.quantizer.size())
+		case "list":
+			elem := "r." + f.Elem + "()"
+			if f.elem != nil {
+				elem = "read" + f.Elem + "(r)"
+			}
+			w.p("%s%s = [];", indent, v)
+			w.p("%sfor (let n = r.uint16(); n > 0; n--) %s.push(%s);", indent, v, elem)
+		case "union":
+			w.p("%sswitch (r.uint8()) {", indent)
+			for _, m := range f.of {
+				w.p("%s\tcase Msg%s:", indent, m.Name)
+				w.p("%s\t\t%s = read%s(r);", indent, v, m.Name)
+				w.p("%s\t\tbreak;", indent)
+			}
+			w.p("%s\tdefault:", indent)
+			w.p("%s\t\tthrow new ProtocolError(\"unknown message type\");", indent)
+			w.p("%s}", indent)
+		default:
+			w.p("%s%s = r.%s();", indent, v, f.Type)
+		}
+		if f.Flag != "" {
+			w.p("\t}")
+		}
+	}
+	w.p("\treturn m;")
+	w.p("}")
+	w.p("")
+}
+
+func tsType(f *Field) string {
+	switch f.Type {
+	case "bool":
+		return "boolean"
+	case "string":
+		return "string"
+	case "bytes":
+		return "Uint8Array"
+	case "quantized":
+		return "number[]"
+	case "list":
+		if f.elem != nil {
+			return f.Elem + "[]"
+		}
+		return "number[]"
+	case "union":
+		return strings.Join(f.Of, " | ")
+	}
+	return "number"
+}
+
+// tsName turns a go field name into camel case, like InputSeq into inputSeq and RTT into rtt.
+func tsName(name string) string {
+	runes := []rune(name)
+	for i := range runes {
+		if !unicode.IsUpper(runes[i]) {
+			break
+		}
+		// Keep the last capital of an initialism that's followed by another word, like URLPath into urlPath.
+		if i > 0 && i+1 < len(runes) && !unicode.IsUpper(runes[i+1]) {
+			break
+		}
+		runes[i] = unicode.ToLower(runes[i])
+	}
+	return string(runes)
+}
+
+func tsFloat(f float32) string {
+	if inv := 1 / float64(f); inv > 1 && inv == math.Trunc(inv) {
+		return "1 / " + strconv.Itoa(int(inv))
+	}
+	return strconv.FormatFloat(float64(f), 'g', -1, 32)
+}
+
+// tsRuntime reads the wire format, and unpacks quantized fields exactly like the go quantize package.
+const tsRuntime = `export class ProtocolError extends Error {
+	constructor(message: string) {
+		super("protocol: " + message);
+	}
+}
+
+const textDecoder = new TextDecoder();
+
+class Reader {
+	private view: DataView;
+	private offset = 0;
+
+	constructor(private data: Uint8Array) {
+		this.view = new DataView(data.buffer, data.byteOffset, data.byteLength);
+	}
+
+	private take(n: number): number {
+		if (this.offset + n > this.data.byteLength) throw new ProtocolError("message truncated");
+		const offset = this.offset;
+		this.offset += n;
+		return offset;
+	}
+
+	uint8(): number {
+		return this.view.getUint8(this.take(1));
+	}
+
+	uint16(): number {
+		return this.view.getUint16(this.take(2), true);
+	}
+
+	uint32(): number {
+		return this.view.getUint32(this.take(4), true);
+	}
+
+	float32(): number {
+		return this.view.getFloat32(this.take(4), true);
+	}
+
+	float64(): number {
+		return this.view.getFloat64(this.take(8), true);
+	}
+
+	bool(): boolean {
+		const v = this.uint8();
+		if (v > 1) throw new ProtocolError("invalid field");
+		return v === 1;
+	}
+
+	string(): string {
+		const n = this.uint8();
+		const offset = this.take(n);
+		return textDecoder.decode(this.data.subarray(offset, offset + n));
+	}
+
+	bytes(n: number): Uint8Array {
+		const offset = this.take(n);
+		return this.data.slice(offset, offset + n);
+	}
+
+	/** reads a little endian value of n bytes */
+	packed(n: number): bigint {
+		const offset = this.take(n);
+		let v = 0n;
+		for (let i = n - 1; i >= 0; i--) v = (v << 8n) | BigInt(this.data[offset + i]);
+		return v;
+	}
+
+	done() {
+		if (this.offset < this.data.byteLength) throw new ProtocolError("unexpected data after message");
+	}
+}
+
+class Range {
+	readonly steps: number;
+	readonly bits: number;
+
+	constructor(
+		readonly min: number,
+		readonly max: number,
+		readonly precision: number,
+	) {
+		this.steps = Math.round((max - min) / precision);
+		this.bits = 32 - Math.clz32(this.steps);
+	}
+
+	dequantize(q: number): number {
+		return Math.fround(this.min + Math.min(q, this.steps) * this.precision);
+	}
+}
+
+class VectorQuantizer {
+	constructor(readonly ranges: Range[]) {}
+
+	unpack(packed: bigint): number[] {
+		const v = [0, 0, 0];
+		for (let i = this.ranges.length - 1; i >= 0; i--) {
+			const bits = BigInt(this.ranges[i].bits);
+			v[i] = this.ranges[i].dequantize(Number(packed & ((1n << bits) - 1n)));
+			packed >>= bits;
+		}
+		return v;
+	}
+}
+
+class RotationQuantizer {
+	private half: number;
+
+	constructor(readonly bits: number) {
+		this.half = 2 ** (bits - 1) - 1;
+	}
+
+	/** rebuilds the dropped component of a quaternion compressed to its three smallest components */
+	unpack(packed: bigint): number[] {
+		const bits = BigInt(this.bits);
+		const largest = Number((packed >> (3n * bits)) & 3n);
+		const q = [0, 0, 0, 0];
+		let sum = 0;
+		for (let i = 3; i >= 0; i--) {
+			if (i === largest) continue;
+			const v = Number(packed & ((1n << bits) - 1n));
+			packed >>= bits;
+			q[i] = Math.fround(((v - this.half) / this.half) * Math.SQRT1_2);
+			sum += q[i] * q[i];
+		}
+		q[largest] = Math.fround(Math.sqrt(Math.max(0, 1 - sum)));
+		const l = Math.sqrt(q[0] * q[0] + q[1] * q[1] + q[2] * q[2] + q[3] * q[3]);
+		if (l === 0 || Number.isNaN(l)) return [0, 0, 0, 1];
+		return q.map((v) => Math.fround(v / l));
+	}
+}`
@@ -2,8 +2,9 @@ module webgl-multiplayer
 
 go 1.23.3
 
+require github.com/lxzan/gws v1.8.8
+
 require (
 	github.com/dolthub/maphash v0.1.0 // indirect
 	github.com/klauspost/compress v1.17.5 // indirect
-	github.com/lxzan/gws v1.8.8 // indirect
 )
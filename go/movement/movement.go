@@ -0,0 +1,239 @@
+// Package movement is the character movement shared by the backend, which runs it authoritatively,
+// and the wasm client, which runs it to predict the local player ahead of the server.
+//
+// Both must get bit for bit the same results from the same input, or every prediction ends in a correction.
+// So the movement sticks to float32 arithmetic, rounds every product explicitly before it's added to anything
+// so the compiler can't fuse it into an FMA on some platforms and not others,
+// and uses its own sine and cosine rather than whatever the math package is built with.
+package movement
+
+import "math"
+
+// Character tuning.
+const (
+	WalkSpeed    = 6.0  // units per second
+	GroundAccel  = 10.0 // times the walk speed per second, while on the ground
+	AirAccel     = 1.0  // times the walk speed per second, while in the air
+	Friction     = 6.0  // fraction of the ground speed lost per second
+	StopSpeed    = 1.5  // below this speed, friction acts as if moving this fast so characters come to a stop
+	Gravity      = 20.0 // units per second squared
+	JumpSpeed    = 7.0  // upwards velocity of a jump
+	MaxFallSpeed = 50.0
+
+	HalfWidth = 0.4 // the character's box, around its position on the x and z axes
+	Height    = 1.8 // the character's box, above its position
+	EyeHeight = 1.6 // the camera, above the character's position
+
+	MaxPitch = math.Pi/2 - 0.0001
+
+	// groundEpsilon is how close a character's feet must be to a surface to stand on it.
+	groundEpsilon = 1e-3
+	// overlapEpsilon is how far a character can start a move inside a box and still be pushed back out of it.
+	overlapEpsilon = 1e-3
+)
+
+// Input buttons, sent as a bitmask.
+const (
+	ButtonForward uint8 = 1 << iota
+	ButtonBack
+	ButtonLeft
+	ButtonRight
+	ButtonJump
+)
+
+// Input is a character's controls for a single step.
+type Input struct {
+	Buttons uint8
+	Yaw     float32 // radians, 0 looks down -z
+	Pitch   float32 // radians, positive looks up
+}
+
+// State is everything about a character that carries over between steps.
+// Whether it's on the ground is worked out from its position, so it doesn't need to be replicated.
+type State struct {
+	Position [3]float32 // at the character's feet
+	Velocity [3]float32 // units per second
+}
+
+// Box is an axis aligned box characters collide with.
+type Box struct {
+	Min [3]float32
+	Max [3]float32
+}
+
+// Level is the static geometry characters move through: a ground plane at y = 0,
+// walls at ±Bounds on the x and z axes, and any boxes.
+type Level struct {
+	Bounds float32
+	Boxes  []Box
+}
+
+// DefaultLevel is the level the game is played in.
+var DefaultLevel = Level{
+	Bounds: 150,
+}
+
+// ClampInput wraps the yaw into [-π, π] and limits the pitch so the view never flips over.
+// Yaws and pitches that aren't finite are zeroed.
+func ClampInput(in Input) Input {
+	if isNaN(in.Yaw) || math.IsInf(float64(in.Yaw), 0) {
+		in.Yaw = 0
+	}
+	if isNaN(in.Pitch) {
+		in.Pitch = 0
+	}
+	in.Yaw = float32(math.Remainder(float64(in.Yaw), 2*math.Pi))
+	in.Pitch = min(max(in.Pitch, -MaxPitch), MaxPitch)
+	return in
+}
+
+// Step moves a character by its input over dt seconds, colliding with the level.
+func Step(s *State, in Input, level *Level, dt float32) {
+	grounded := s.Velocity[1] <= 0 && onGround(s.Position, level)
+
+	// Walking ignores the pitch, looking down doesn't slow you down.
+	var x, z float32
+	if in.Buttons&ButtonRight != 0 {
+		x++
+	}
+	if in.Buttons&ButtonLeft != 0 {
+		x--
+	}
+	if in.Buttons&ButtonForward != 0 {
+		z++
+	}
+	if in.Buttons&ButtonBack != 0 {
+		z--
+	}
+	sin, cos := Sincos(in.Yaw)
+	wish := [2]float32{
+		float32(cos*x) + float32(sin*z),
+		float32(sin*x) - float32(cos*z),
+	}
+	if l := float32(math.Sqrt(float64(float32(wish[0]*wish[0]) + float32(wish[1]*wish[1])))); l > 0 {
+		wish[0], wish[1] = wish[0]/l, wish[1]/l
+	}
+
+	if grounded {
+		applyFriction(s, dt)
+		accelerate(s, wish, GroundAccel, dt)
+		if in.Buttons&ButtonJump != 0 {
+			s.Velocity[1] = JumpSpeed
+		}
+	} else {
+		accelerate(s, wish, AirAccel, dt)
+	}
+	s.Velocity[1] = max(s.Velocity[1]-float32(Gravity*dt), -MaxFallSpeed)
+
+	// Move one axis at a time, so hitting something on one axis still slides along the others.
+	for _, axis := range [3]int{0, 2, 1} {
+		move(s, axis, float32(s.Velocity[axis]*dt), level)
+	}
+}
+
+// applyFriction slows the character down on the ground.
+func applyFriction(s *State, dt float32) {
+	speed := float32(math.Sqrt(float64(float32(s.Velocity[0]*s.Velocity[0]) + float32(s.Velocity[2]*s.Velocity[2]))))
+	if speed == 0 {
+		return
+	}
+	drop := float32(float32(max(speed, StopSpeed)*Friction) * dt)
+	scale := max(speed-drop, 0) / speed
+	s.Velocity[0] = float32(s.Velocity[0] * scale)
+	s.Velocity[2] = float32(s.Velocity[2] * scale)
+}
+
+// accelerate speeds the character up in the wished direction, up to the walk speed.
+// Only the speed along the wished direction is capped, so turning in the air can't be used to gain speed.
+func accelerate(s *State, wish [2]float32, accel float32, dt float32) {
+	current := float32(s.Velocity[0]*wish[0]) + float32(s.Velocity[2]*wish[1])
+	add := WalkSpeed - current
+	if add <= 0 {
+		return
+	}
+	speed := min(float32(float32(accel*WalkSpeed)*dt), add)
+	s.Velocity[0] += float32(speed * wish[0])
+	s.Velocity[2] += float32(speed * wish[1])
+}
+
+// move moves the character along an axis, stopping it against whatever it runs into.
+func move(s *State, axis int, delta float32, level *Level) {
+	if delta == 0 {
+		return
+	}
+	p := &s.Position
+	p[axis] += delta
+
+	for i := range level.Boxes {
+		b := &level.Boxes[i]
+		if !overlaps(*p, b) {
+			continue
+		}
+		var face, depth float32
+		switch {
+		case axis == 1 && delta < 0:
+			face = b.Max[1]
+			depth = face - p[1]
+		case axis == 1:
+			face = b.Min[1] - Height
+			depth = p[1] - face
+		case delta < 0:
+			face = b.Max[axis] + HalfWidth
+			depth = face - p[axis]
+		default:
+			face = b.Min[axis] - HalfWidth
+			depth = p[axis] - face
+		}
+		// Only push back out of boxes this move went into. A character can start a move slightly inside a box,
+		// from rounding its position to what's replicated, and pushing it out along another axis would warp it
+		// to the far side of the box.
+		if depth > abs(delta)+overlapEpsilon {
+			continue
+		}
+		p[axis] = face
+		s.Velocity[axis] = 0
+	}
+
+	switch {
+	case axis == 1 && p[1] < 0:
+		p[1] = 0
+		s.Velocity[1] = 0
+	case axis != 1 && p[axis] < -level.Bounds+HalfWidth:
+		p[axis] = -level.Bounds + HalfWidth
+		s.Velocity[axis] = 0
+	case axis != 1 && p[axis] > level.Bounds-HalfWidth:
+		p[axis] = level.Bounds - HalfWidth
+		s.Velocity[axis] = 0
+	}
+}
+
+// overlaps reports whether a character at the position is inside the box.
+func overlaps(p [3]float32, b *Box) bool {
+	return p[0]+HalfWidth > b.Min[0] && p[0]-HalfWidth < b.Max[0] &&
+		p[1]+Height > b.Min[1] && p[1] < b.Max[1] &&
+		p[2]+HalfWidth > b.Min[2] && p[2]-HalfWidth < b.Max[2]
+}
+
+// onGround reports whether a character at the position is standing on the ground or on top of a box.
+func onGround(p [3]float32, level *Level) bool {
+	if p[1] <= groundEpsilon {
+		return true
+	}
+	for i := range level.Boxes {
+		b := &level.Boxes[i]
+		if p[1] >= b.Max[1]-groundEpsilon && p[1] <= b.Max[1]+groundEpsilon &&
+			p[0]+HalfWidth > b.Min[0] && p[0]-HalfWidth < b.Max[0] &&
+			p[2]+HalfWidth > b.Min[2] && p[2]-HalfWidth < b.Max[2] {
+			return true
+		}
+	}
+	return false
+}
+
+func abs(f float32) float32 {
+	return float32(math.Abs(float64(f)))
+}
+
+func isNaN(f float32) bool {
+	return f != f
+}
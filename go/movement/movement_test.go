@@ -0,0 +1,148 @@
+package movement
+
+import (
+	"encoding/binary"
+	"hash/fnv"
+	"math"
+	"math/rand"
+	"testing"
+)
+
+const testDt = float32(1.0 / 30)
+
+var testLevel = Level{
+	Bounds: 20,
+	Boxes: []Box{
+		{Min: [3]float32{2, 0, -6}, Max: [3]float32{4, 1, -2}},
+		{Min: [3]float32{-5, 0, 3}, Max: [3]float32{-1, 2.5, 4}},
+		{Min: [3]float32{-8, 3, -8}, Max: [3]float32{8, 3.5, -7}},
+	},
+}
+
+// testInputs is a long, reproducible sequence of inputs that walks, turns, and jumps into everything in the test level.
+func testInputs(n int) []Input {
+	r := rand.New(rand.NewSource(1))
+	inputs := make([]Input, n)
+	in := Input{}
+	for i := range inputs {
+		if i%15 == 0 {
+			in.Buttons = uint8(r.Intn(32))
+			in.Yaw = float32(r.Float64()*4*math.Pi - 2*math.Pi)
+			in.Pitch = float32(r.Float64()*2 - 1)
+		}
+		inputs[i] = ClampInput(in)
+	}
+	return inputs
+}
+
+func run(s State, inputs []Input) State {
+	for _, in := range inputs {
+		Step(&s, in, &testLevel, testDt)
+	}
+	return s
+}
+
+func hashState(s State) uint64 {
+	h := fnv.New64a()
+	for _, f := range append(s.Position[:], s.Velocity[:]...) {
+		h.Write(binary.LittleEndian.AppendUint32(nil, math.Float32bits(f)))
+	}
+	return h.Sum64()
+}
+
+func TestSincos(t *testing.T) {
+	for x := -math.Pi; x <= math.Pi; x += 0.001 {
+		sin, cos := Sincos(float32(x))
+		wantSin, wantCos := math.Sincos(float64(float32(x)))
+		if math.Abs(float64(sin)-wantSin) > 1e-6 || math.Abs(float64(cos)-wantCos) > 1e-6 {
+			t.Fatalf("Sincos(%v) = %v, %v, expected %v, %v", x, sin, cos, wantSin, wantCos)
+		}
+	}
+}
+
+// TestDeterministic checks the same inputs always end in the same state, bit for bit.
+func TestDeterministic(t *testing.T) {
+	inputs := testInputs(3000)
+	first := run(State{}, inputs)
+	for range 3 {
+		if s := run(State{}, inputs); s != first {
+			t.Fatalf("got %+v, expected %+v", s, first)
+		}
+	}
+}
+
+// TestReplay checks that resuming from a copy of the state, as the client does when it reconciles,
+// ends in the same state as stepping straight through.
+func TestReplay(t *testing.T) {
+	inputs := testInputs(3000)
+	straight := run(State{}, inputs)
+	for _, split := range []int{1, 500, 1234, 2999} {
+		if s := run(run(State{}, inputs[:split]), inputs[split:]); s != straight {
+			t.Fatalf("split at %d: got %+v, expected %+v", split, s, straight)
+		}
+	}
+}
+
+// TestGolden pins the result of the test inputs, so a platform or compiler that rounds differently shows up here
+// rather than as constant corrections in game. If the movement is changed on purpose, update the hash.
+func TestGolden(t *testing.T) {
+	const golden = 0xfe9bab525fa53a84
+	s := run(State{}, testInputs(3000))
+	if h := hashState(s); h != golden {
+		t.Fatalf("state hash %#x, expected %#x, final state %+v", h, uint64(golden), s)
+	}
+}
+
+func TestJumpLands(t *testing.T) {
+	s := State{}
+	Step(&s, Input{Buttons: ButtonJump}, &testLevel, testDt)
+	if s.Position[1] <= 0 {
+		t.Fatalf("expected to leave the ground, at %v", s.Position)
+	}
+	for range 60 {
+		Step(&s, Input{}, &testLevel, testDt)
+	}
+	if s.Position[1] != 0 || s.Velocity[1] != 0 {
+		t.Fatalf("expected to land, at %v moving %v", s.Position, s.Velocity)
+	}
+}
+
+func TestCollidesWithBox(t *testing.T) {
+	// Walk down +x into the first box's -x face.
+	s := State{Position: [3]float32{0, 0, -4}}
+	right := Input{Buttons: ButtonForward, Yaw: math.Pi / 2}
+	for range 60 {
+		Step(&s, right, &testLevel, testDt)
+	}
+	if want := testLevel.Boxes[0].Min[0] - HalfWidth; s.Position[0] != want || s.Velocity[0] != 0 {
+		t.Fatalf("expected to stop at x = %v, at %v moving %v", want, s.Position, s.Velocity)
+	}
+}
+
+func TestStandsOnBox(t *testing.T) {
+	s := State{Position: [3]float32{3, 2, -4}}
+	for range 30 {
+		Step(&s, Input{}, &testLevel, testDt)
+	}
+	if s.Position[1] != testLevel.Boxes[0].Max[1] {
+		t.Fatalf("expected to land on the box, at %v", s.Position)
+	}
+	Step(&s, Input{Buttons: ButtonJump}, &testLevel, testDt)
+	if s.Velocity[1] <= 0 {
+		t.Fatalf("expected to jump off the box, moving %v", s.Velocity)
+	}
+}
+
+// TestSlidesAlongBoxFromInside checks a character starting a move slightly inside a box's side,
+// as rounding to the replicated position can leave it, keeps sliding along the side rather than being warped off it.
+func TestSlidesAlongBoxFromInside(t *testing.T) {
+	b := testLevel.Boxes[0]
+	s := State{Position: [3]float32{b.Min[0] - HalfWidth + 0.0005, 0, -3}}
+	back := Input{Buttons: ButtonBack}
+	for range 5 {
+		Step(&s, back, &testLevel, testDt)
+	}
+	if s.Position[0] != b.Min[0]-HalfWidth+0.0005 || s.Position[2] <= -3 {
+		t.Fatalf("expected to slide along the box, at %v", s.Position)
+	}
+}
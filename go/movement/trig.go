@@ -0,0 +1,37 @@
+package movement
+
+import "math"
+
+// Taylor series coefficients of sin and cos, accurate to well under float32 precision on [-π/4, π/4].
+var (
+	sinCoefficients = [...]float64{1, -1.0 / 6, 1.0 / 120, -1.0 / 5040, 1.0 / 362880, -1.0 / 39916800}
+	cosCoefficients = [...]float64{1, -1.0 / 2, 1.0 / 24, -1.0 / 720, 1.0 / 40320, -1.0 / 3628800}
+)
+
+// Sincos returns the sine and cosine of x, computed the same way on every platform.
+// It's meant for angles from ClampInput, larger angles lose precision to the range reduction.
+func Sincos(x float32) (sin, cos float32) {
+	// Reduce to [-π/4, π/4] around the nearest multiple of π/2.
+	v := float64(x)
+	quadrant := math.Round(v / (math.Pi / 2))
+	r := v - float64(quadrant*(math.Pi/2))
+	r2 := float64(r * r)
+
+	var s, c float64
+	for i := len(sinCoefficients) - 1; i >= 0; i-- {
+		s = float64(s*r2) + sinCoefficients[i]
+		c = float64(c*r2) + cosCoefficients[i]
+	}
+	s = float64(s * r)
+
+	switch int(quadrant) & 3 {
+	case 0:
+		return float32(s), float32(c)
+	case 1:
+		return float32(c), float32(-s)
+	case 2:
+		return float32(-s), float32(-c)
+	default:
+		return float32(-c), float32(s)
+	}
+}
@@ -0,0 +1,72 @@
+package protocol
+
+import (
+	"reflect"
+	"testing"
+)
+
+// fuzzMessage decodes arbitrary bodies as messages of the same type as m. Decoding must never panic,
+// and a body that decodes must encode back to the same size and decode again.
+// Encoding again doesn't have to give the same bytes, quantized rotations can round differently the second time.
+func fuzzMessage(f *testing.F, name string, m Message) {
+	golden, err := readGolden(name)
+	if err != nil {
+		f.Fatal(err)
+	}
+	f.Add(golden)
+	f.Add([]byte{})
+	typ := reflect.TypeOf(m).Elem()
+	f.Fuzz(func(t *testing.T, body []byte) {
+		m := reflect.New(typ).Interface().(Message)
+		if err := m.UnmarshalBinary(body); err != nil {
+			return
+		}
+		b, err := m.MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(b) != len(body) || m.Size() != len(body) {
+			t.Fatalf("decoded %d bytes, encoded again in %d with a Size of %d", len(body), len(b), m.Size())
+		}
+		again := reflect.New(typ).Interface().(Message)
+		if err := again.UnmarshalBinary(b); err != nil {
+			t.Fatalf("decoded and encoded again as %x, which doesn't decode: %v", b, err)
+		}
+	})
+}
+
+// FuzzDecode decodes arbitrary server messages, headers included, like the wasm client does.
+func FuzzDecode(f *testing.F) {
+	for _, g := range goldenMessages {
+		if newServerMessage(g.message.Type()) != nil {
+			f.Add(Encode(1, g.message))
+		}
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		h, m, err := Decode(data)
+		if err != nil {
+			return
+		}
+		if b := Encode(h.Tick, m); len(b) != len(data) {
+			t.Fatalf("decoded %d bytes, encoded again in %d", len(data), len(b))
+		}
+	})
+}
+
+// FuzzDecodeClient decodes arbitrary client messages, like the server does with everything clients send.
+func FuzzDecodeClient(f *testing.F) {
+	for _, g := range goldenMessages {
+		if newClientMessage(g.message.Type()) != nil {
+			f.Add(EncodeClient(g.message))
+		}
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		m, err := DecodeClient(data)
+		if err != nil {
+			return
+		}
+		if b := EncodeClient(m); len(b) != len(data) {
+			t.Fatalf("decoded %d bytes, encoded again in %d", len(data), len(b))
+		}
+	})
+}
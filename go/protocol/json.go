@@ -0,0 +1,173 @@
+//go:build jsonwire
+
+package protocol
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// The JSON encoding is a debug wire format, for reading and hand-crafting traffic in the browser's devtools.
+// Messages are sent as text frames in it, with the same fields as in the binary encoding:
+//
+//	{"type": "Snapshot", "tick": 42, "body": {"baseline": 40, "entities": [...], "removed": []}}
+//
+// Client messages have no tick, and union fields are written like a message without one.
+// Messages go through the binary encoding on their way in and out, so they only ever carry what it can:
+// quantized fields are quantized, and anything it can't encode fails to decode.
+
+// JSONWire is whether the JSON encoding is built in. It's built with the jsonwire tag,
+// the encoding/json package it needs would more than double the size of the wasm client otherwise.
+const JSONWire = true
+
+// ErrMissingBody is a JSON message or union field without a body.
+var ErrMissingBody = errors.New("protocol: missing body")
+
+// jsonMessage is a message in JSON.
+type jsonMessage struct {
+	Type string          `json:"type"`
+	Tick *uint32         `json:"tick,omitempty"`
+	Body json.RawMessage `json:"body"`
+}
+
+// EncodeJSON encodes a server message sent on the given tick as JSON.
+// It fails only on floats JSON can't represent, like NaN.
+func EncodeJSON(tick uint32, m Message) ([]byte, error) {
+	return encodeJSON(&tick, m)
+}
+
+// EncodeClientJSON encodes a client message as JSON.
+func EncodeClientJSON(m Message) ([]byte, error) {
+	return encodeJSON(nil, m)
+}
+
+// DecodeJSON decodes a server message encoded as JSON.
+func DecodeJSON(data []byte) (Header, Message, error) {
+	var v jsonMessage
+	if err := unmarshalStrict(data, &v); err != nil {
+		return Header{}, nil, err
+	}
+	if v.Tick == nil {
+		return Header{}, nil, errors.New("protocol: missing tick")
+	}
+	h := Header{Tick: *v.Tick}
+	m, err := decodeJSONBody(v.Type, v.Body, newServerMessage)
+	if m != nil {
+		h.Type = m.Type()
+	}
+	return h, m, err
+}
+
+// DecodeClientJSON decodes a client message encoded as JSON.
+func DecodeClientJSON(data []byte) (Message, error) {
+	var v jsonMessage
+	if err := unmarshalStrict(data, &v); err != nil {
+		return nil, err
+	}
+	if v.Tick != nil {
+		return nil, errors.New("protocol: client messages have no tick")
+	}
+	return decodeJSONBody(v.Type, v.Body, newClientMessage)
+}
+
+func encodeJSON(tick *uint32, m Message) ([]byte, error) {
+	body, err := json.Marshal(canonical(m))
+	if err != nil {
+		return nil, fmt.Errorf("protocol: %w", err)
+	}
+	return json.Marshal(jsonMessage{Type: messageNames[m.Type()], Tick: tick, Body: body})
+}
+
+// decodeJSONBody decodes the body of a message of the named type, if newType knows the type.
+func decodeJSONBody(name string, body json.RawMessage, newType func(byte) Message) (Message, error) {
+	t, ok := messageType(name)
+	if !ok {
+		return nil, ErrUnknownMessage
+	}
+	m := newType(t)
+	if m == nil {
+		return nil, ErrUnknownMessage
+	}
+	if len(body) == 0 {
+		return nil, ErrMissingBody
+	}
+	if err := unmarshalStrict(body, m); err != nil {
+		return nil, err
+	}
+	// Going through the binary encoding fails on anything it couldn't have carried, like unknown flags.
+	decoded := newType(t)
+	if err := decoded.UnmarshalBinary(m.Append(nil)); err != nil {
+		return nil, err
+	}
+	return decoded, nil
+}
+
+// canonical returns the message as it's decoded from its binary encoding.
+func canonical(m Message) Message {
+	decoded := newMessage(m.Type())
+	if decoded == nil || decoded.UnmarshalBinary(m.Append(nil)) != nil {
+		return m
+	}
+	return decoded
+}
+
+// marshalUnion encodes the message in a union field.
+func marshalUnion(m Message) (json.RawMessage, error) {
+	if m == nil {
+		return nil, ErrMissingBody
+	}
+	body, err := json.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(jsonMessage{Type: messageNames[m.Type()], Body: body})
+}
+
+// unmarshalUnion decodes the message in a union field, which has to be one of the given types.
+func unmarshalUnion(data json.RawMessage, types ...byte) (Message, error) {
+	if len(data) == 0 {
+		return nil, ErrMissingBody
+	}
+	var v jsonMessage
+	if err := unmarshalStrict(data, &v); err != nil {
+		return nil, err
+	}
+	if v.Tick != nil {
+		return nil, errors.New("protocol: union fields have no tick")
+	}
+	return decodeJSONBody(v.Type, v.Body, func(t byte) Message {
+		for _, allowed := range types {
+			if t == allowed {
+				return newMessage(t)
+			}
+		}
+		return nil
+	})
+}
+
+// messageType returns the type of the named message.
+func messageType(name string) (byte, bool) {
+	for t, n := range messageNames {
+		if n == name {
+			return t, true
+		}
+	}
+	return 0, false
+}
+
+// unmarshalStrict decodes a single JSON value, failing on unknown fields and anything after it.
+// Being strict catches typos in hand-crafted messages.
+func unmarshalStrict(data []byte, v any) error {
+	d := json.NewDecoder(bytes.NewReader(data))
+	d.DisallowUnknownFields()
+	if err := d.Decode(v); err != nil {
+		return fmt.Errorf("protocol: %w", err)
+	}
+	if _, err := d.Token(); err != io.EOF {
+		return ErrTrailingData
+	}
+	return nil
+}
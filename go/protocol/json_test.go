@@ -0,0 +1,111 @@
+//go:build jsonwire
+
+package protocol
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// encodeJSONFrame encodes a message in JSON like it's sent, with a tick if it's a server message.
+func encodeJSONFrame(m Message) ([]byte, error) {
+	if newClientMessage(m.Type()) != nil {
+		return EncodeClientJSON(m)
+	}
+	return EncodeJSON(7, m)
+}
+
+// decodeJSONFrame decodes a message encoded by encodeJSONFrame.
+func decodeJSONFrame(data []byte) (Message, error) {
+	if m, err := DecodeClientJSON(data); err == nil {
+		return m, nil
+	}
+	_, m, err := DecodeJSON(data)
+	return m, err
+}
+
+// TestJSON checks every message decodes from JSON to exactly the message it was encoded from.
+func TestJSON(t *testing.T) {
+	for _, g := range goldenMessages {
+		if g.message.Type() < 0x80 && newServerMessage(g.message.Type()) == nil {
+			continue // events are only sent in an Event
+		}
+		t.Run(g.name, func(t *testing.T) {
+			data, err := encodeJSONFrame(g.message)
+			if err != nil {
+				t.Fatal(err)
+			}
+			decoded, err := decodeJSONFrame(data)
+			if err != nil {
+				t.Fatalf("decoding %s: %v", data, err)
+			}
+			want, _ := g.message.MarshalBinary()
+			if got, _ := decoded.MarshalBinary(); !bytes.Equal(got, want) {
+				t.Fatalf("decoded %s as\n%x\nexpected\n%x", data, got, want)
+			}
+		})
+	}
+}
+
+func TestDecodeJSON(t *testing.T) {
+	h, m, err := DecodeJSON([]byte(`{"type": "Event", "tick": 3, "body": {"seq": 9, "event": {"type": "Chat", "body": {"client": 1, "text": "hi"}}}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if chat, ok := m.(*Event).Event.(*Chat); h.Type != MsgEvent || h.Tick != 3 || !ok || chat.Text != "hi" {
+		t.Fatalf("decoded %+v %+v", h, m)
+	}
+
+	for _, data := range []string{
+		``,
+		`{"type": "Input", "body": {"seq": 1}} {}`,
+		`{"type": "Input", "body": {"seq": 1, "yaww": 2}}`,
+		`{"type": "Input", "body": {"buttons": 256}}`,
+		`{"type": "Input", "tick": 1, "body": {}}`,
+		`{"type": "Input"}`,
+		`{"type": "Snapshot", "body": {}}`,
+		`{"type": "Nope", "body": {}}`,
+	} {
+		if m, err := DecodeClientJSON([]byte(data)); err == nil {
+			t.Fatalf("decoded %q as %+v", data, m)
+		}
+	}
+	for _, data := range []string{
+		`{"type": "Welcome", "body": {}}`,
+		`{"type": "Input", "tick": 1, "body": {}}`,
+		`{"type": "Chat", "tick": 1, "body": {}}`,
+		`{"type": "Event", "tick": 1, "body": {"seq": 1}}`,
+		`{"type": "Event", "tick": 1, "body": null}`,
+		`{"type": "Event", "tick": 1, "body": {"seq": 1, "event": {"type": "Welcome", "body": {}}}}`,
+		`{"type": "Snapshot", "tick": 1, "body": {"entities": [{"id": 1, "fields": 255}]}}`,
+	} {
+		if _, m, err := DecodeJSON([]byte(data)); err == nil {
+			t.Fatalf("decoded %q as %+v", data, m)
+		}
+	}
+}
+
+// FuzzDecodeClientJSON decodes arbitrary client messages in JSON, like the server does in the debug wire format.
+func FuzzDecodeClientJSON(f *testing.F) {
+	for _, g := range goldenMessages {
+		if newClientMessage(g.message.Type()) != nil {
+			data, _ := EncodeClientJSON(g.message)
+			f.Add(data)
+		}
+	}
+	f.Add([]byte(`{"type": "ChatRequest", "body": {"text": "` + strings.Repeat("a", 300) + `"}}`))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		m, err := DecodeClientJSON(data)
+		if err != nil {
+			return
+		}
+		encoded, err := EncodeClientJSON(m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := DecodeClientJSON(encoded); err != nil {
+			t.Fatalf("decoded and encoded again as %s, which doesn't decode: %v", encoded, err)
+		}
+	})
+}
@@ -0,0 +1,883 @@
+// Code generated by protogen from schema.json. DO NOT EDIT.
+
+package protocol
+
+import (
+	"encoding/binary"
+
+	"webgl-multiplayer/quantize"
+)
+
+// ResumeTokenSize is the size of a session's resume token.
+const ResumeTokenSize = 16
+
+// NoOwner is the owner of entities that don't belong to a client.
+const NoOwner = 65535
+
+// Message types, server messages below 0x80 and client messages from it.
+// Event messages are server messages only ever sent inside a union field, like Event's.
+const (
+	MsgWelcome     byte = 1
+	MsgJoined      byte = 2
+	MsgLeft        byte = 3
+	MsgRoster      byte = 4
+	MsgLatencies   byte = 5
+	MsgTime        byte = 6
+	MsgSnapshot    byte = 7
+	MsgEvent       byte = 8
+	MsgChat        byte = 9
+	MsgKill        byte = 10
+	MsgPickup      byte = 11
+	MsgTimeRequest byte = 0x80
+	MsgInput       byte = 0x81
+	MsgAck         byte = 0x82
+	MsgEventAck    byte = 0x83
+	MsgChatRequest byte = 0x84
+)
+
+// Quantizers of the quantized fields.
+var (
+	// Position covers the level with a precision of 1/1024 units. Packed in 8 bytes.
+	Position = quantize.Vector{
+		{Min: -256, Max: 256, Precision: 1.0 / 1024},
+		{Min: -32, Max: 96, Precision: 1.0 / 1024},
+		{Min: -256, Max: 256, Precision: 1.0 / 1024},
+	}
+	// Rotation is within about 0.1 degrees. Packed in 4 bytes.
+	Rotation = quantize.Rotation{Bits: 10}
+	// Velocity covers up to 64 units per second with a precision of 1/256 units per second. Packed in 6 bytes.
+	Velocity = quantize.Vector{
+		{Min: -64, Max: 64, Precision: 1.0 / 256},
+		{Min: -64, Max: 64, Precision: 1.0 / 256},
+		{Min: -64, Max: 64, Precision: 1.0 / 256},
+	}
+)
+
+// Encoded sizes of the quantized fields.
+const (
+	positionSize = 8
+	rotationSize = 4
+	velocitySize = 6
+)
+
+// RosterEntry is a client in the roster.
+//
+//   - 2 bytes: client ID (uint16)
+//   - 1 byte: length, followed by the display name (utf-8)
+type RosterEntry struct {
+	Client uint16 `json:"client"`
+	Name   string `json:"name"`
+}
+
+func (m *RosterEntry) size() int {
+	size := 2
+	size += stringSize(m.Name)
+	return size
+}
+
+func (m *RosterEntry) append(b []byte) []byte {
+	b = binary.LittleEndian.AppendUint16(b, m.Client)
+	b = appendString(b, m.Name)
+	return b
+}
+
+func (m *RosterEntry) read(r *reader) {
+	m.Client = r.uint16()
+	m.Name = r.string()
+}
+
+// Latency is a client's round trip time in milliseconds.
+//
+//   - 2 bytes: client ID (uint16)
+//   - 2 bytes: round trip time in milliseconds (uint16)
+type Latency struct {
+	Client uint16 `json:"client"`
+	RTT    uint16 `json:"rtt"`
+}
+
+func (m *Latency) size() int { return 4 }
+
+func (m *Latency) append(b []byte) []byte {
+	b = binary.LittleEndian.AppendUint16(b, m.Client)
+	b = binary.LittleEndian.AppendUint16(b, m.RTT)
+	return b
+}
+
+func (m *Latency) read(r *reader) {
+	m.Client = r.uint16()
+	m.RTT = r.uint16()
+}
+
+// EntityUpdate fields, set in its Fields mask when they're encoded.
+const (
+	FieldOwner uint8 = 1 << iota
+	FieldModel
+	FieldPosition
+	FieldRotation
+	FieldVelocity
+	FieldInputSeq
+
+	FieldsAll = FieldOwner | FieldModel | FieldPosition | FieldRotation | FieldVelocity | FieldInputSeq
+)
+
+// EntityUpdate is a new or changed entity in a snapshot. Only the fields in the mask are encoded,
+// new entities have every field.
+//
+//   - 4 bytes: entity ID (uint32)
+//   - 1 byte: mask of the fields that follow
+//   - FieldOwner, 2 bytes: client ID, NoOwner if the entity has no owner (uint16)
+//   - FieldModel, 1 byte: model ID
+//   - FieldPosition, 8 bytes: x, y, z quantized to Position
+//   - FieldRotation, 4 bytes: quaternion x, y, z, w quantized to Rotation
+//   - FieldVelocity, 6 bytes: x, y, z in units per second quantized to Velocity
+//   - FieldInputSeq, 4 bytes: sequence number of the owner's last input command applied to the entity (uint32)
+type EntityUpdate struct {
+	ID       uint32     `json:"id"`
+	Fields   uint8      `json:"fields"`
+	Owner    uint16     `json:"owner"`
+	Model    uint8      `json:"model"`
+	Position [3]float32 `json:"position"`
+	Rotation [4]float32 `json:"rotation"`
+	Velocity [3]float32 `json:"velocity"`
+	InputSeq uint32     `json:"inputSeq"`
+}
+
+func (m *EntityUpdate) size() int {
+	size := 5
+	if m.Fields&FieldOwner != 0 {
+		size += 2
+	}
+	if m.Fields&FieldModel != 0 {
+		size += 1
+	}
+	if m.Fields&FieldPosition != 0 {
+		size += 8
+	}
+	if m.Fields&FieldRotation != 0 {
+		size += 4
+	}
+	if m.Fields&FieldVelocity != 0 {
+		size += 6
+	}
+	if m.Fields&FieldInputSeq != 0 {
+		size += 4
+	}
+	return size
+}
+
+func (m *EntityUpdate) append(b []byte) []byte {
+	b = binary.LittleEndian.AppendUint32(b, m.ID)
+	b = append(b, m.Fields)
+	if m.Fields&FieldOwner != 0 {
+		b = binary.LittleEndian.AppendUint16(b, m.Owner)
+	}
+	if m.Fields&FieldModel != 0 {
+		b = append(b, m.Model)
+	}
+	if m.Fields&FieldPosition != 0 {
+		b = appendPacked(b, Position.Pack(m.Position), positionSize)
+	}
+	if m.Fields&FieldRotation != 0 {
+		b = appendPacked(b, Rotation.Pack(m.Rotation), rotationSize)
+	}
+	if m.Fields&FieldVelocity != 0 {
+		b = appendPacked(b, Velocity.Pack(m.Velocity), velocitySize)
+	}
+	if m.Fields&FieldInputSeq != 0 {
+		b = binary.LittleEndian.AppendUint32(b, m.InputSeq)
+	}
+	return b
+}
+
+func (m *EntityUpdate) read(r *reader) {
+	m.ID = r.uint32()
+	m.Fields = r.uint8()
+	if m.Fields&^FieldsAll != 0 {
+		r.fail(ErrInvalidField)
+	}
+	if m.Fields&FieldOwner != 0 {
+		m.Owner = r.uint16()
+	}
+	if m.Fields&FieldModel != 0 {
+		m.Model = r.uint8()
+	}
+	if m.Fields&FieldPosition != 0 {
+		m.Position = Position.Unpack(r.packed(positionSize))
+	}
+	if m.Fields&FieldRotation != 0 {
+		m.Rotation = Rotation.Unpack(r.packed(rotationSize))
+	}
+	if m.Fields&FieldVelocity != 0 {
+		m.Velocity = Velocity.Unpack(r.packed(velocitySize))
+	}
+	if m.Fields&FieldInputSeq != 0 {
+		m.InputSeq = r.uint32()
+	}
+}
+
+// Welcome is the first message a client gets after registering or resuming.
+//
+//   - 2 bytes: client ID (uint16)
+//   - 1 byte: whether the client resumed an existing session, 1 or 0
+//   - 16 bytes: resume token, presented in the resume query parameter to resume the session
+type Welcome struct {
+	Client  uint16                `json:"client"`
+	Resumed bool                  `json:"resumed"`
+	Token   [ResumeTokenSize]byte `json:"token"`
+}
+
+func (m *Welcome) Type() byte { return MsgWelcome }
+func (m *Welcome) Size() int  { return 19 }
+
+func (m *Welcome) Append(b []byte) []byte {
+	b = binary.LittleEndian.AppendUint16(b, m.Client)
+	b = appendBool(b, m.Resumed)
+	b = append(b, m.Token[:]...)
+	return b
+}
+
+func (m *Welcome) MarshalBinary() ([]byte, error) {
+	return m.Append(make([]byte, 0, m.Size())), nil
+}
+
+func (m *Welcome) UnmarshalBinary(body []byte) error {
+	r := reader{data: body}
+	m.read(&r)
+	return r.done()
+}
+
+func (m *Welcome) read(r *reader) {
+	m.Client = r.uint16()
+	m.Resumed = r.bool()
+	copy(m.Token[:], r.take(len(m.Token)))
+}
+
+// Joined is a client joining the game.
+//
+//   - 2 bytes: client ID (uint16)
+//   - 1 byte: length, followed by the display name (utf-8)
+type Joined struct {
+	Client uint16 `json:"client"`
+	Name   string `json:"name"`
+}
+
+func (m *Joined) Type() byte { return MsgJoined }
+
+func (m *Joined) Size() int {
+	size := 2
+	size += stringSize(m.Name)
+	return size
+}
+
+func (m *Joined) Append(b []byte) []byte {
+	b = binary.LittleEndian.AppendUint16(b, m.Client)
+	b = appendString(b, m.Name)
+	return b
+}
+
+func (m *Joined) MarshalBinary() ([]byte, error) {
+	return m.Append(make([]byte, 0, m.Size())), nil
+}
+
+func (m *Joined) UnmarshalBinary(body []byte) error {
+	r := reader{data: body}
+	m.read(&r)
+	return r.done()
+}
+
+func (m *Joined) read(r *reader) {
+	m.Client = r.uint16()
+	m.Name = r.string()
+}
+
+// Left is a client leaving the game.
+//
+//   - 2 bytes: client ID (uint16)
+//   - 1 byte: reason, 0 quit, 1 timeout, 2 kicked
+type Left struct {
+	Client uint16 `json:"client"`
+	Reason uint8  `json:"reason"`
+}
+
+func (m *Left) Type() byte { return MsgLeft }
+func (m *Left) Size() int  { return 3 }
+
+func (m *Left) Append(b []byte) []byte {
+	b = binary.LittleEndian.AppendUint16(b, m.Client)
+	b = append(b, m.Reason)
+	return b
+}
+
+func (m *Left) MarshalBinary() ([]byte, error) {
+	return m.Append(make([]byte, 0, m.Size())), nil
+}
+
+func (m *Left) UnmarshalBinary(body []byte) error {
+	r := reader{data: body}
+	m.read(&r)
+	return r.done()
+}
+
+func (m *Left) read(r *reader) {
+	m.Client = r.uint16()
+	m.Reason = r.uint8()
+}
+
+// Roster is every client in the game, sent to new clients when they join.
+//
+//   - 2 bytes: number of clients (uint16), followed by each of them, RosterEntry
+type Roster struct {
+	Clients []RosterEntry `json:"clients"`
+}
+
+func (m *Roster) Type() byte { return MsgRoster }
+
+func (m *Roster) Size() int {
+	size := 2
+	for i := range m.Clients {
+		size += m.Clients[i].size()
+	}
+	return size
+}
+
+func (m *Roster) Append(b []byte) []byte {
+	b = binary.LittleEndian.AppendUint16(b, uint16(len(m.Clients)))
+	for i := range m.Clients {
+		b = m.Clients[i].append(b)
+	}
+	return b
+}
+
+func (m *Roster) MarshalBinary() ([]byte, error) {
+	return m.Append(make([]byte, 0, m.Size())), nil
+}
+
+func (m *Roster) UnmarshalBinary(body []byte) error {
+	r := reader{data: body}
+	m.read(&r)
+	return r.done()
+}
+
+func (m *Roster) read(r *reader) {
+	n, capacity := r.count(3)
+	m.Clients = make([]RosterEntry, 0, capacity)
+	for i := 0; i < n && r.err == nil; i++ {
+		var e RosterEntry
+		e.read(r)
+		m.Clients = append(m.Clients, e)
+	}
+}
+
+// Latencies is every client's round trip time, for the scoreboard.
+//
+//   - 2 bytes: number of clients (uint16), followed by each of them, Latency
+type Latencies struct {
+	Clients []Latency `json:"clients"`
+}
+
+func (m *Latencies) Type() byte { return MsgLatencies }
+
+func (m *Latencies) Size() int {
+	size := 2
+	size += len(m.Clients) * 4
+	return size
+}
+
+func (m *Latencies) Append(b []byte) []byte {
+	b = binary.LittleEndian.AppendUint16(b, uint16(len(m.Clients)))
+	for i := range m.Clients {
+		b = m.Clients[i].append(b)
+	}
+	return b
+}
+
+func (m *Latencies) MarshalBinary() ([]byte, error) {
+	return m.Append(make([]byte, 0, m.Size())), nil
+}
+
+func (m *Latencies) UnmarshalBinary(body []byte) error {
+	r := reader{data: body}
+	m.read(&r)
+	return r.done()
+}
+
+func (m *Latencies) read(r *reader) {
+	n, capacity := r.count(4)
+	m.Clients = make([]Latency, 0, capacity)
+	for i := 0; i < n && r.err == nil; i++ {
+		var e Latency
+		e.read(r)
+		m.Clients = append(m.Clients, e)
+	}
+}
+
+// Time is the answer to a clock sync request.
+// All server times are in milliseconds since the server started.
+//
+//   - 8 bytes: client time the request was sent at, echoed back (float64)
+//   - 8 bytes: server time the request was received at (float64)
+//   - 8 bytes: server time the response was sent at (float64)
+//   - 8 bytes: server time of tick 0 (float64)
+//   - 8 bytes: tick interval in milliseconds (float64)
+type Time struct {
+	ClientSent     float64 `json:"clientSent"`
+	ServerReceived float64 `json:"serverReceived"`
+	ServerSent     float64 `json:"serverSent"`
+	TickOrigin     float64 `json:"tickOrigin"`
+	TickInterval   float64 `json:"tickInterval"`
+}
+
+func (m *Time) Type() byte { return MsgTime }
+func (m *Time) Size() int  { return 40 }
+
+func (m *Time) Append(b []byte) []byte {
+	b = appendFloat64(b, m.ClientSent)
+	b = appendFloat64(b, m.ServerReceived)
+	b = appendFloat64(b, m.ServerSent)
+	b = appendFloat64(b, m.TickOrigin)
+	b = appendFloat64(b, m.TickInterval)
+	return b
+}
+
+func (m *Time) MarshalBinary() ([]byte, error) {
+	return m.Append(make([]byte, 0, m.Size())), nil
+}
+
+func (m *Time) UnmarshalBinary(body []byte) error {
+	r := reader{data: body}
+	m.read(&r)
+	return r.done()
+}
+
+func (m *Time) read(r *reader) {
+	m.ClientSent = r.float64()
+	m.ServerReceived = r.float64()
+	m.ServerSent = r.float64()
+	m.TickOrigin = r.float64()
+	m.TickInterval = r.float64()
+}
+
+// Snapshot is the changes to the replicated entities since the baseline snapshot,
+// or every entity if it's a full snapshot. The snapshot's tick is the one in its header.
+//
+//   - 4 bytes: tick of the baseline snapshot, the snapshot's own tick if it's a full snapshot (uint32)
+//   - 2 bytes: number of new or changed entities (uint16), followed by each of them, EntityUpdate
+//   - 2 bytes: number of IDs of the removed entities (uint16), followed by each of them, 4 bytes (uint32)
+type Snapshot struct {
+	Baseline uint32         `json:"baseline"`
+	Entities []EntityUpdate `json:"entities"`
+	Removed  []uint32       `json:"removed"`
+}
+
+func (m *Snapshot) Type() byte { return MsgSnapshot }
+
+func (m *Snapshot) Size() int {
+	size := 8
+	for i := range m.Entities {
+		size += m.Entities[i].size()
+	}
+	size += len(m.Removed) * 4
+	return size
+}
+
+func (m *Snapshot) Append(b []byte) []byte {
+	b = binary.LittleEndian.AppendUint32(b, m.Baseline)
+	b = binary.LittleEndian.AppendUint16(b, uint16(len(m.Entities)))
+	for i := range m.Entities {
+		b = m.Entities[i].append(b)
+	}
+	b = binary.LittleEndian.AppendUint16(b, uint16(len(m.Removed)))
+	for _, v := range m.Removed {
+		b = binary.LittleEndian.AppendUint32(b, v)
+	}
+	return b
+}
+
+func (m *Snapshot) MarshalBinary() ([]byte, error) {
+	return m.Append(make([]byte, 0, m.Size())), nil
+}
+
+func (m *Snapshot) UnmarshalBinary(body []byte) error {
+	r := reader{data: body}
+	m.read(&r)
+	return r.done()
+}
+
+func (m *Snapshot) read(r *reader) {
+	m.Baseline = r.uint32()
+	n, capacity := r.count(5)
+	m.Entities = make([]EntityUpdate, 0, capacity)
+	for i := 0; i < n && r.err == nil; i++ {
+		var e EntityUpdate
+		e.read(r)
+		m.Entities = append(m.Entities, e)
+	}
+	n, capacity = r.count(4)
+	m.Removed = make([]uint32, 0, capacity)
+	for i := 0; i < n && r.err == nil; i++ {
+		m.Removed = append(m.Removed, r.uint32())
+	}
+}
+
+// Event is a reliable event, delivered in order and exactly once.
+// Every event in a room gets the next sequence number, clients acknowledge the latest one they received with EventAck.
+// The server keeps sending a client's unacknowledged events after it resumes its session,
+// so a client can get events it already has again and must skip those it's seen.
+//
+//   - 4 bytes: sequence number (uint32)
+//   - 1 byte: event type, followed by the event, one of Joined, Left, Roster, Chat, Kill, Pickup
+type Event struct {
+	Seq   uint32  `json:"seq"`
+	Event Message `json:"event"`
+}
+
+func (m *Event) Type() byte { return MsgEvent }
+
+func (m *Event) Size() int {
+	size := 5
+	size += m.Event.Size()
+	return size
+}
+
+func (m *Event) Append(b []byte) []byte {
+	b = binary.LittleEndian.AppendUint32(b, m.Seq)
+	b = append(b, m.Event.Type())
+	b = m.Event.Append(b)
+	return b
+}
+
+func (m *Event) MarshalBinary() ([]byte, error) {
+	return m.Append(make([]byte, 0, m.Size())), nil
+}
+
+func (m *Event) UnmarshalBinary(body []byte) error {
+	r := reader{data: body}
+	m.read(&r)
+	return r.done()
+}
+
+func (m *Event) read(r *reader) {
+	m.Seq = r.uint32()
+	switch r.uint8() {
+	case MsgJoined:
+		m.Event = &Joined{}
+	case MsgLeft:
+		m.Event = &Left{}
+	case MsgRoster:
+		m.Event = &Roster{}
+	case MsgChat:
+		m.Event = &Chat{}
+	case MsgKill:
+		m.Event = &Kill{}
+	case MsgPickup:
+		m.Event = &Pickup{}
+	default:
+		r.fail(ErrUnknownMessage)
+		return
+	}
+	r.fail(m.Event.UnmarshalBinary(r.rest()))
+}
+
+// Chat is a chat message from a client.
+//
+//   - 2 bytes: client ID (uint16)
+//   - 1 byte: length, followed by the message (utf-8)
+type Chat struct {
+	Client uint16 `json:"client"`
+	Text   string `json:"text"`
+}
+
+func (m *Chat) Type() byte { return MsgChat }
+
+func (m *Chat) Size() int {
+	size := 2
+	size += stringSize(m.Text)
+	return size
+}
+
+func (m *Chat) Append(b []byte) []byte {
+	b = binary.LittleEndian.AppendUint16(b, m.Client)
+	b = appendString(b, m.Text)
+	return b
+}
+
+func (m *Chat) MarshalBinary() ([]byte, error) {
+	return m.Append(make([]byte, 0, m.Size())), nil
+}
+
+func (m *Chat) UnmarshalBinary(body []byte) error {
+	r := reader{data: body}
+	m.read(&r)
+	return r.done()
+}
+
+func (m *Chat) read(r *reader) {
+	m.Client = r.uint16()
+	m.Text = r.string()
+}
+
+// Kill is a client's player killing another's.
+//
+//   - 2 bytes: killer's client ID, NoOwner if the victim wasn't killed by a player (uint16)
+//   - 2 bytes: victim's client ID (uint16)
+type Kill struct {
+	Killer uint16 `json:"killer"`
+	Victim uint16 `json:"victim"`
+}
+
+func (m *Kill) Type() byte { return MsgKill }
+func (m *Kill) Size() int  { return 4 }
+
+func (m *Kill) Append(b []byte) []byte {
+	b = binary.LittleEndian.AppendUint16(b, m.Killer)
+	b = binary.LittleEndian.AppendUint16(b, m.Victim)
+	return b
+}
+
+func (m *Kill) MarshalBinary() ([]byte, error) {
+	return m.Append(make([]byte, 0, m.Size())), nil
+}
+
+func (m *Kill) UnmarshalBinary(body []byte) error {
+	r := reader{data: body}
+	m.read(&r)
+	return r.done()
+}
+
+func (m *Kill) read(r *reader) {
+	m.Killer = r.uint16()
+	m.Victim = r.uint16()
+}
+
+// Pickup is a client's player picking up an item.
+//
+//   - 2 bytes: client ID (uint16)
+//   - 4 bytes: entity ID of the item (uint32)
+//   - 1 byte: item type
+type Pickup struct {
+	Client uint16 `json:"client"`
+	Item   uint32 `json:"item"`
+	Kind   uint8  `json:"kind"`
+}
+
+func (m *Pickup) Type() byte { return MsgPickup }
+func (m *Pickup) Size() int  { return 7 }
+
+func (m *Pickup) Append(b []byte) []byte {
+	b = binary.LittleEndian.AppendUint16(b, m.Client)
+	b = binary.LittleEndian.AppendUint32(b, m.Item)
+	b = append(b, m.Kind)
+	return b
+}
+
+func (m *Pickup) MarshalBinary() ([]byte, error) {
+	return m.Append(make([]byte, 0, m.Size())), nil
+}
+
+func (m *Pickup) UnmarshalBinary(body []byte) error {
+	r := reader{data: body}
+	m.read(&r)
+	return r.done()
+}
+
+func (m *Pickup) read(r *reader) {
+	m.Client = r.uint16()
+	m.Item = r.uint32()
+	m.Kind = r.uint8()
+}
+
+// TimeRequest is a client's clock sync request.
+//
+//   - 8 bytes: client time the request was sent at, in milliseconds (float64)
+type TimeRequest struct {
+	Sent float64 `json:"sent"`
+}
+
+func (m *TimeRequest) Type() byte { return MsgTimeRequest }
+func (m *TimeRequest) Size() int  { return 8 }
+
+func (m *TimeRequest) Append(b []byte) []byte {
+	b = appendFloat64(b, m.Sent)
+	return b
+}
+
+func (m *TimeRequest) MarshalBinary() ([]byte, error) {
+	return m.Append(make([]byte, 0, m.Size())), nil
+}
+
+func (m *TimeRequest) UnmarshalBinary(body []byte) error {
+	r := reader{data: body}
+	m.read(&r)
+	return r.done()
+}
+
+func (m *TimeRequest) read(r *reader) {
+	m.Sent = r.float64()
+}
+
+// Input is a client's input command for a single tick.
+//
+//   - 4 bytes: sequence number, counting up from 1 for every command the client sends (uint32)
+//   - 1 byte: buttons held, the movement package's ButtonForward, ButtonBack, ButtonLeft, ButtonRight, ButtonJump bits
+//   - 4 bytes: yaw in radians (float32)
+//   - 4 bytes: pitch in radians (float32)
+type Input struct {
+	Seq     uint32  `json:"seq"`
+	Buttons uint8   `json:"buttons"`
+	Yaw     float32 `json:"yaw"`
+	Pitch   float32 `json:"pitch"`
+}
+
+func (m *Input) Type() byte { return MsgInput }
+func (m *Input) Size() int  { return 13 }
+
+func (m *Input) Append(b []byte) []byte {
+	b = binary.LittleEndian.AppendUint32(b, m.Seq)
+	b = append(b, m.Buttons)
+	b = appendFloat32s(b, m.Yaw)
+	b = appendFloat32s(b, m.Pitch)
+	return b
+}
+
+func (m *Input) MarshalBinary() ([]byte, error) {
+	return m.Append(make([]byte, 0, m.Size())), nil
+}
+
+func (m *Input) UnmarshalBinary(body []byte) error {
+	r := reader{data: body}
+	m.read(&r)
+	return r.done()
+}
+
+func (m *Input) read(r *reader) {
+	m.Seq = r.uint32()
+	m.Buttons = r.uint8()
+	m.Yaw = r.float32()
+	m.Pitch = r.float32()
+}
+
+// Ack is a client acknowledging the latest snapshot it received.
+//
+//   - 4 bytes: tick of the snapshot (uint32)
+type Ack struct {
+	Tick uint32 `json:"tick"`
+}
+
+func (m *Ack) Type() byte { return MsgAck }
+func (m *Ack) Size() int  { return 4 }
+
+func (m *Ack) Append(b []byte) []byte {
+	b = binary.LittleEndian.AppendUint32(b, m.Tick)
+	return b
+}
+
+func (m *Ack) MarshalBinary() ([]byte, error) {
+	return m.Append(make([]byte, 0, m.Size())), nil
+}
+
+func (m *Ack) UnmarshalBinary(body []byte) error {
+	r := reader{data: body}
+	m.read(&r)
+	return r.done()
+}
+
+func (m *Ack) read(r *reader) {
+	m.Tick = r.uint32()
+}
+
+// EventAck is a client acknowledging every event up to and including a sequence number.
+//
+//   - 4 bytes: sequence number of the latest event received (uint32)
+type EventAck struct {
+	Seq uint32 `json:"seq"`
+}
+
+func (m *EventAck) Type() byte { return MsgEventAck }
+func (m *EventAck) Size() int  { return 4 }
+
+func (m *EventAck) Append(b []byte) []byte {
+	b = binary.LittleEndian.AppendUint32(b, m.Seq)
+	return b
+}
+
+func (m *EventAck) MarshalBinary() ([]byte, error) {
+	return m.Append(make([]byte, 0, m.Size())), nil
+}
+
+func (m *EventAck) UnmarshalBinary(body []byte) error {
+	r := reader{data: body}
+	m.read(&r)
+	return r.done()
+}
+
+func (m *EventAck) read(r *reader) {
+	m.Seq = r.uint32()
+}
+
+// ChatRequest is a client sending a chat message, relayed to everyone as a Chat event.
+//
+//   - 1 byte: length, followed by the message (utf-8)
+type ChatRequest struct {
+	Text string `json:"text"`
+}
+
+func (m *ChatRequest) Type() byte { return MsgChatRequest }
+
+func (m *ChatRequest) Size() int {
+	size := 0
+	size += stringSize(m.Text)
+	return size
+}
+
+func (m *ChatRequest) Append(b []byte) []byte {
+	b = appendString(b, m.Text)
+	return b
+}
+
+func (m *ChatRequest) MarshalBinary() ([]byte, error) {
+	return m.Append(make([]byte, 0, m.Size())), nil
+}
+
+func (m *ChatRequest) UnmarshalBinary(body []byte) error {
+	r := reader{data: body}
+	m.read(&r)
+	return r.done()
+}
+
+func (m *ChatRequest) read(r *reader) {
+	m.Text = r.string()
+}
+
+// newServerMessage returns an empty server message of the given type, nil if there's no such message.
+func newServerMessage(t byte) Message {
+	switch t {
+	case MsgWelcome:
+		return &Welcome{}
+	case MsgLatencies:
+		return &Latencies{}
+	case MsgTime:
+		return &Time{}
+	case MsgSnapshot:
+		return &Snapshot{}
+	case MsgEvent:
+		return &Event{}
+	}
+	return nil
+}
+
+// newClientMessage returns an empty client message of the given type, nil if there's no such message.
+func newClientMessage(t byte) Message {
+	switch t {
+	case MsgTimeRequest:
+		return &TimeRequest{}
+	case MsgInput:
+		return &Input{}
+	case MsgAck:
+		return &Ack{}
+	case MsgEventAck:
+		return &EventAck{}
+	case MsgChatRequest:
+		return &ChatRequest{}
+	}
+	return nil
+}
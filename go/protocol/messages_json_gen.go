@@ -0,0 +1,92 @@
+// Code generated by protogen from schema.json. DO NOT EDIT.
+
+//go:build jsonwire
+
+package protocol
+
+import "encoding/json"
+
+// messageNames are the names of the message types, as they're written in JSON.
+var messageNames = map[byte]string{
+	MsgWelcome:     "Welcome",
+	MsgJoined:      "Joined",
+	MsgLeft:        "Left",
+	MsgRoster:      "Roster",
+	MsgLatencies:   "Latencies",
+	MsgTime:        "Time",
+	MsgSnapshot:    "Snapshot",
+	MsgEvent:       "Event",
+	MsgChat:        "Chat",
+	MsgKill:        "Kill",
+	MsgPickup:      "Pickup",
+	MsgTimeRequest: "TimeRequest",
+	MsgInput:       "Input",
+	MsgAck:         "Ack",
+	MsgEventAck:    "EventAck",
+	MsgChatRequest: "ChatRequest",
+}
+
+// newMessage returns an empty message of the given type, events included, nil if there's no such message.
+func newMessage(t byte) Message {
+	switch t {
+	case MsgWelcome:
+		return &Welcome{}
+	case MsgJoined:
+		return &Joined{}
+	case MsgLeft:
+		return &Left{}
+	case MsgRoster:
+		return &Roster{}
+	case MsgLatencies:
+		return &Latencies{}
+	case MsgTime:
+		return &Time{}
+	case MsgSnapshot:
+		return &Snapshot{}
+	case MsgEvent:
+		return &Event{}
+	case MsgChat:
+		return &Chat{}
+	case MsgKill:
+		return &Kill{}
+	case MsgPickup:
+		return &Pickup{}
+	case MsgTimeRequest:
+		return &TimeRequest{}
+	case MsgInput:
+		return &Input{}
+	case MsgAck:
+		return &Ack{}
+	case MsgEventAck:
+		return &EventAck{}
+	case MsgChatRequest:
+		return &ChatRequest{}
+	}
+	return nil
+}
+
+func (m *Event) MarshalJSON() ([]byte, error) {
+	union, err := marshalUnion(m.Event)
+	if err != nil {
+		return nil, err
+	}
+	type fields Event
+	return json.Marshal(struct {
+		*fields
+		Event json.RawMessage `json:"event"`
+	}{(*fields)(m), union})
+}
+
+func (m *Event) UnmarshalJSON(data []byte) error {
+	type fields Event
+	v := struct {
+		*fields
+		Event json.RawMessage `json:"event"`
+	}{fields: (*fields)(m)}
+	if err := unmarshalStrict(data, &v); err != nil {
+		return err
+	}
+	var err error
+	m.Event, err = unmarshalUnion(v.Event, MsgJoined, MsgLeft, MsgRoster, MsgChat, MsgKill, MsgPickup)
+	return err
+}
@@ -0,0 +1,32 @@
+//go:build !jsonwire
+
+package protocol
+
+import "errors"
+
+// JSONWire is whether the JSON encoding is built in. It's built with the jsonwire tag,
+// the encoding/json package it needs would more than double the size of the wasm client otherwise.
+const JSONWire = false
+
+// ErrNoJSONWire is returned by the JSON functions when the JSON encoding isn't built in.
+var ErrNoJSONWire = errors.New("protocol: built without the jsonwire tag")
+
+// EncodeJSON fails, see JSONWire.
+func EncodeJSON(tick uint32, m Message) ([]byte, error) {
+	return nil, ErrNoJSONWire
+}
+
+// EncodeClientJSON fails, see JSONWire.
+func EncodeClientJSON(m Message) ([]byte, error) {
+	return nil, ErrNoJSONWire
+}
+
+// DecodeJSON fails, see JSONWire.
+func DecodeJSON(data []byte) (Header, Message, error) {
+	return Header{}, nil, ErrNoJSONWire
+}
+
+// DecodeClientJSON fails, see JSONWire.
+func DecodeClientJSON(data []byte) (Message, error) {
+	return nil, ErrNoJSONWire
+}
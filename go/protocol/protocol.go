@@ -0,0 +1,144 @@
+// Package protocol is the wire format shared by the backend and the wasm client.
+//
+// Every message starts with a single byte message type. Messages from the server follow it with the tick
+// they were sent on, clients don't have ticks. The body after that depends on the message type:
+// fixed size fields in little endian, like the .bobj format, with strings and lists prefixed by their length.
+//
+// Messages can also be encoded as JSON, a debug wire format for reading traffic in the browser's devtools, see json.go.
+// It's only built with the jsonwire build tag, other builds leave it out and fail to encode or decode JSON.
+//
+// The message types are generated from schema.json by cmd/protogen, along with their golden tests
+// and the frontend's TypeScript decoder. Change the schema rather than the generated files, then regenerate.
+package protocol
+
+//go:generate go run ../cmd/protogen -schema schema.json -go messages_gen.go -json messages_json_gen.go -test messages_gen_test.go -ts ../../frontend/src/game/protocol.ts
+
+import (
+	"encoding/binary"
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// Version is the version of the protocol, bumped on every change to the wire format.
+// Clients offer the versions they speak as WebSocket subprotocols when they connect,
+// and are turned away if the server doesn't speak any of them.
+const Version = 3
+
+// MinVersion is the oldest version still spoken, clients offering only older ones have to update.
+const MinVersion = Version
+
+// SubprotocolPrefix starts the WebSocket subprotocol of every version, followed by the version number.
+const SubprotocolPrefix = "webgpu-mp.v"
+
+// Machine readable close reasons of version mismatches.
+const (
+	ReasonUpdateRequired = "update_required" // the client is too old for the server
+	ReasonServerOutdated = "server_outdated" // the client is too new for the server
+)
+
+// HeaderSize is the size of the header every server message starts with.
+const HeaderSize = 5
+
+var (
+	ErrTruncated      = errors.New("protocol: message truncated")
+	ErrTrailingData   = errors.New("protocol: unexpected data after message")
+	ErrUnknownMessage = errors.New("protocol: unknown message type")
+	ErrInvalidField   = errors.New("protocol: invalid field")
+)
+
+// Subprotocol returns the WebSocket subprotocol a version is negotiated as.
+func Subprotocol(version int) string {
+	return SubprotocolPrefix + strconv.Itoa(version)
+}
+
+// ParseSubprotocol returns the version of a WebSocket subprotocol, if it's one of this protocol's.
+func ParseSubprotocol(subprotocol string) (int, bool) {
+	v, ok := strings.CutPrefix(subprotocol, SubprotocolPrefix)
+	if !ok {
+		return 0, false
+	}
+	version, err := strconv.Atoi(v)
+	if err != nil || version < 1 {
+		return 0, false
+	}
+	return version, true
+}
+
+// Subprotocols returns the WebSocket subprotocols of every version still spoken, newest first.
+func Subprotocols() []string {
+	subprotocols := make([]string, 0, Version-MinVersion+1)
+	for v := Version; v >= MinVersion; v-- {
+		subprotocols = append(subprotocols, Subprotocol(v))
+	}
+	return subprotocols
+}
+
+// Message is the body of a message, encoded after its header.
+type Message interface {
+	// Type is the message type the body is sent with.
+	Type() byte
+	// Size is the encoded size of the body.
+	Size() int
+	// Append appends the encoded body.
+	Append(b []byte) []byte
+	// MarshalBinary encodes the body.
+	MarshalBinary() ([]byte, error)
+	// UnmarshalBinary decodes the body, failing if it's truncated or followed by anything.
+	UnmarshalBinary(body []byte) error
+}
+
+// Header is the header of a server message.
+//
+//   - 1 byte: message type
+//   - 4 bytes: tick the message was sent on (uint32)
+type Header struct {
+	Type byte
+	Tick uint32
+}
+
+// Encode encodes a server message sent on the given tick.
+func Encode(tick uint32, m Message) []byte {
+	b := make([]byte, 0, HeaderSize+m.Size())
+	b = append(b, m.Type())
+	b = binary.LittleEndian.AppendUint32(b, tick)
+	return m.Append(b)
+}
+
+// EncodeClient encodes a client message.
+func EncodeClient(m Message) []byte {
+	b := make([]byte, 0, 1+m.Size())
+	b = append(b, m.Type())
+	return m.Append(b)
+}
+
+// Decode decodes a server message.
+func Decode(data []byte) (Header, Message, error) {
+	if len(data) < HeaderSize {
+		return Header{}, nil, ErrTruncated
+	}
+	h := Header{Type: data[0], Tick: binary.LittleEndian.Uint32(data[1:HeaderSize])}
+	m := newServerMessage(h.Type)
+	if m == nil {
+		return h, nil, ErrUnknownMessage
+	}
+	if err := m.UnmarshalBinary(data[HeaderSize:]); err != nil {
+		return h, nil, err
+	}
+	return h, m, nil
+}
+
+// DecodeClient decodes a client message.
+func DecodeClient(data []byte) (Message, error) {
+	if len(data) < 1 {
+		return nil, ErrTruncated
+	}
+	m := newClientMessage(data[0])
+	if m == nil {
+		return nil, ErrUnknownMessage
+	}
+	if err := m.UnmarshalBinary(data[1:]); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
@@ -0,0 +1,21 @@
+package protocol
+
+import "encoding/binary"
+
+// The quantizers are declared in the schema.
+// The backend rounds the characters it simulates to them after every tick, and the wasm client's prediction does the same,
+// so the state a client rewinds to when it reconciles is exactly the server's.
+
+// appendPacked appends the low size bytes of a packed value.
+func appendPacked(b []byte, packed uint64, size int) []byte {
+	var buf [8]byte
+	binary.LittleEndian.PutUint64(buf[:], packed)
+	return append(b, buf[:size]...)
+}
+
+// packed reads a packed value of size bytes.
+func (r *reader) packed(size int) uint64 {
+	var buf [8]byte
+	copy(buf[:], r.take(size))
+	return binary.LittleEndian.Uint64(buf[:])
+}
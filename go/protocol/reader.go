@@ -0,0 +1,133 @@
+package protocol
+
+import (
+	"encoding/binary"
+	"math"
+)
+
+// reader decodes fields from the front of a message body.
+// Reading past the end sets the error and returns zeros from then on, so a decoder only has to check once at the end.
+type reader struct {
+	data []byte
+	err  error
+}
+
+// take returns the next n bytes, or nil if there aren't that many left.
+func (r *reader) take(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if n < 0 || n > len(r.data) {
+		r.err = ErrTruncated
+		r.data = nil
+		return nil
+	}
+	b := r.data[:n]
+	r.data = r.data[n:]
+	return b
+}
+
+// fail sets the error, unless there already is one.
+func (r *reader) fail(err error) {
+	if r.err == nil {
+		r.err = err
+	}
+}
+
+// rest returns everything left.
+func (r *reader) rest() []byte {
+	return r.take(len(r.data))
+}
+
+func (r *reader) uint8() uint8 {
+	if b := r.take(1); b != nil {
+		return b[0]
+	}
+	return 0
+}
+
+// bool reads a byte that must be 0 or 1.
+func (r *reader) bool() bool {
+	v := r.uint8()
+	if v > 1 {
+		r.fail(ErrInvalidField)
+	}
+	return v == 1
+}
+
+func (r *reader) uint16() uint16 {
+	if b := r.take(2); b != nil {
+		return binary.LittleEndian.Uint16(b)
+	}
+	return 0
+}
+
+func (r *reader) uint32() uint32 {
+	if b := r.take(4); b != nil {
+		return binary.LittleEndian.Uint32(b)
+	}
+	return 0
+}
+
+func (r *reader) float32() float32 {
+	return math.Float32frombits(r.uint32())
+}
+
+func (r *reader) float64() float64 {
+	if b := r.take(8); b != nil {
+		return math.Float64frombits(binary.LittleEndian.Uint64(b))
+	}
+	return 0
+}
+
+// string reads a string prefixed by its length in a single byte.
+func (r *reader) string() string {
+	return string(r.take(int(r.uint8())))
+}
+
+// count reads a uint16 list length, and returns how many of the list's elements to make room for.
+// A list can't have more elements than there are bytes left for, whatever its length claims.
+func (r *reader) count(minSize int) (n int, capacity int) {
+	n = int(r.uint16())
+	return n, min(n, len(r.data)/minSize)
+}
+
+// done returns the first error, or ErrTrailingData if the body wasn't read to the end.
+func (r *reader) done() error {
+	if r.err == nil && len(r.data) > 0 {
+		return ErrTrailingData
+	}
+	return r.err
+}
+
+func appendFloat32s(b []byte, fs ...float32) []byte {
+	for _, f := range fs {
+		b = binary.LittleEndian.AppendUint32(b, math.Float32bits(f))
+	}
+	return b
+}
+
+func appendFloat64(b []byte, f float64) []byte {
+	return binary.LittleEndian.AppendUint64(b, math.Float64bits(f))
+}
+
+// appendString appends a string prefixed by its length in a single byte, truncating it to 255 bytes.
+func appendString(b []byte, s string) []byte {
+	if len(s) > math.MaxUint8 {
+		s = s[:math.MaxUint8]
+	}
+	b = append(b, byte(len(s)))
+	return append(b, s...)
+}
+
+// stringSize is the encoded size of a string, after truncating.
+func stringSize(s string) int {
+	return 1 + min(len(s), math.MaxUint8)
+}
+
+func appendBool(b []byte, v bool) []byte {
+	if v {
+		return append(b, 1)
+	}
+	return append(b, 0)
+}
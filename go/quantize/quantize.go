@@ -0,0 +1,176 @@
+// Package quantize packs floats into fewer bits for network traffic, the same idea as the vertex quantization
+// in utils/bin-obj: positions and velocities become integers within a bounded range,
+// and rotations are compressed to their three smallest components.
+//
+// Quantizing is deterministic, so the backend and the wasm client round values the same way,
+// and both can simulate on exactly the values that go over the wire.
+package quantize
+
+import (
+	"math"
+	"math/bits"
+)
+
+// Range quantizes values between Min and Max to multiples of Precision from Min.
+// Values outside the range are clamped to it. With a power of two precision and a min that's a multiple of it,
+// every quantized value is exactly representable, so rounding an already rounded value doesn't change it.
+type Range struct {
+	Min       float32
+	Max       float32
+	Precision float32
+}
+
+// steps is the largest quantized value.
+func (r Range) steps() uint32 {
+	return uint32(math.Round((float64(r.Max) - float64(r.Min)) / float64(r.Precision)))
+}
+
+// Bits is the number of bits a quantized value takes.
+func (r Range) Bits() int {
+	return bits.Len32(r.steps())
+}
+
+// Quantize returns the step nearest to the value.
+func (r Range) Quantize(v float32) uint32 {
+	if v != v {
+		return 0 // NaN
+	}
+	v = min(max(v, r.Min), r.Max)
+	return min(uint32(math.Round((float64(v)-float64(r.Min))/float64(r.Precision))), r.steps())
+}
+
+// Dequantize returns the value of a step.
+func (r Range) Dequantize(q uint32) float32 {
+	return float32(float64(r.Min) + float64(float64(min(q, r.steps()))*float64(r.Precision)))
+}
+
+// Round returns the value as it is after quantizing.
+func (r Range) Round(v float32) float32 {
+	return r.Dequantize(r.Quantize(v))
+}
+
+// Vector quantizes a vector with a range per axis, packed into a single integer of at most 64 bits.
+type Vector [3]Range
+
+// Bits is the number of bits a packed vector takes.
+func (v *Vector) Bits() int {
+	return v[0].Bits() + v[1].Bits() + v[2].Bits()
+}
+
+// Size is the number of bytes a packed vector takes.
+func (v *Vector) Size() int {
+	return (v.Bits() + 7) / 8
+}
+
+// Pack quantizes every axis and packs them, x in the highest bits.
+func (v *Vector) Pack(f [3]float32) uint64 {
+	var packed uint64
+	for i := range v {
+		packed = packed<<v[i].Bits() | uint64(v[i].Quantize(f[i]))
+	}
+	return packed
+}
+
+// Unpack returns the vector of a packed one.
+func (v *Vector) Unpack(packed uint64) [3]float32 {
+	var f [3]float32
+	for i := len(v) - 1; i >= 0; i-- {
+		n := v[i].Bits()
+		f[i] = v[i].Dequantize(uint32(packed & (1<<n - 1)))
+		packed >>= n
+	}
+	return f
+}
+
+// Round returns the vector as it is after quantizing.
+func (v *Vector) Round(f [3]float32) [3]float32 {
+	for i := range v {
+		f[i] = v[i].Round(f[i])
+	}
+	return f
+}
+
+// smallestThreeMax bounds the three smallest components of a unit quaternion,
+// the largest one is at least as big as the others so none of them can be over 1/√2.
+const smallestThreeMax = math.Sqrt2 / 2
+
+// Rotation compresses unit quaternions x, y, z, w to their three smallest components of Bits bits each,
+// plus 2 bits for which one was dropped. The dropped component is rebuilt from the others when unpacking.
+// Bits can be at most 20, so a packed rotation fits in 64 bits.
+type Rotation struct {
+	Bits int
+}
+
+// Size is the number of bytes a packed rotation takes.
+func (r Rotation) Size() int {
+	return (2 + 3*r.Bits + 7) / 8
+}
+
+// half is the quantized value of a zero component, components are centered on it so zero stays exact.
+func (r Rotation) half() int64 {
+	return 1<<(r.Bits-1) - 1
+}
+
+func (r Rotation) quantize(v float32) uint64 {
+	half := r.half()
+	q := int64(math.Round(float64(v) / smallestThreeMax * float64(half)))
+	return uint64(min(max(q, -half), half) + half)
+}
+
+func (r Rotation) dequantize(q uint64) float32 {
+	half := r.half()
+	return float32(float64(int64(q)-half) / float64(half) * smallestThreeMax)
+}
+
+// Pack compresses a rotation, normalizing it first.
+func (r Rotation) Pack(q [4]float32) uint64 {
+	q = normalize(q)
+	largest := 0
+	for i := 1; i < 4; i++ {
+		if abs(q[i]) > abs(q[largest]) {
+			largest = i
+		}
+	}
+	// q and -q are the same rotation, flip it so the dropped component is positive and can be rebuilt.
+	if q[largest] < 0 {
+		for i := range q {
+			q[i] = -q[i]
+		}
+	}
+	packed := uint64(largest)
+	for i := range q {
+		if i != largest {
+			packed = packed<<r.Bits | r.quantize(q[i])
+		}
+	}
+	return packed
+}
+
+// Unpack returns the unit quaternion of a packed rotation.
+func (r Rotation) Unpack(packed uint64) [4]float32 {
+	largest := int(packed >> (3 * r.Bits) & 3)
+	var q [4]float32
+	sum := 0.0
+	for i := 3; i >= 0; i-- {
+		if i == largest {
+			continue
+		}
+		q[i] = r.dequantize(packed & (1<<r.Bits - 1))
+		packed >>= r.Bits
+		sum += float64(q[i]) * float64(q[i])
+	}
+	q[largest] = float32(math.Sqrt(max(0, 1-sum)))
+	return normalize(q)
+}
+
+func normalize(q [4]float32) [4]float32 {
+	l := math.Sqrt(float64(q[0])*float64(q[0]) + float64(q[1])*float64(q[1]) + float64(q[2])*float64(q[2]) + float64(q[3])*float64(q[3]))
+	if l == 0 || l != l {
+		return [4]float32{0, 0, 0, 1}
+	}
+	return [4]float32{float32(float64(q[0]) / l), float32(float64(q[1]) / l), float32(float64(q[2]) / l), float32(float64(q[3]) / l)}
+}
+
+func abs(f float32) float32 {
+	return float32(math.Abs(float64(f)))
+}
@@ -0,0 +1,113 @@
+package quantize
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+var testVector = Vector{
+	{Min: -256, Max: 256, Precision: 1.0 / 1024},
+	{Min: -32, Max: 96, Precision: 1.0 / 1024},
+	{Min: -256, Max: 256, Precision: 1.0 / 1024},
+}
+
+func TestRangeRoundTrip(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for _, q := range append(testVector[:], Range{Min: -64, Max: 64, Precision: 1.0 / 256}, Range{Min: 0, Max: 10, Precision: 0.3}) {
+		for range 10000 {
+			v := q.Min + float32(r.Float64())*(q.Max-q.Min)
+			if err := math.Abs(float64(q.Round(v) - v)); err > float64(q.Precision)/2+1e-6 {
+				t.Fatalf("%+v: %v rounded to %v, off by %v", q, v, q.Round(v), err)
+			}
+			if q.Quantize(v) >= 1<<q.Bits() {
+				t.Fatalf("%+v: %v quantized to %d, doesn't fit in %d bits", q, v, q.Quantize(v), q.Bits())
+			}
+		}
+	}
+}
+
+func TestRangeClamps(t *testing.T) {
+	q := Range{Min: -64, Max: 64, Precision: 1.0 / 256}
+	for v, want := range map[float32]float32{
+		-1000:                                -64,
+		1000:                                 64,
+		float32(math.Inf(1)):                 64,
+		float32(math.Inf(-1)):                -64,
+		float32(math.NaN()):                  -64,
+		0:                                    0,
+		float32(math.SmallestNonzeroFloat32): 0,
+	} {
+		if got := q.Round(v); got != want {
+			t.Fatalf("%v rounded to %v, expected %v", v, got, want)
+		}
+	}
+}
+
+// TestRangeRoundIsStable checks rounding an already rounded value doesn't change it,
+// which lets the backend simulate on rounded values and send them without any further loss.
+func TestRangeRoundIsStable(t *testing.T) {
+	r := rand.New(rand.NewSource(2))
+	for _, q := range testVector {
+		for range 10000 {
+			v := q.Round(q.Min + float32(r.Float64())*(q.Max-q.Min))
+			if q.Round(v) != v {
+				t.Fatalf("%+v: rounded %v to %v", q, v, q.Round(v))
+			}
+		}
+	}
+}
+
+func TestVectorRoundTrip(t *testing.T) {
+	r := rand.New(rand.NewSource(3))
+	if testVector.Bits() > 64 {
+		t.Fatalf("test vector takes %d bits", testVector.Bits())
+	}
+	for range 10000 {
+		var v [3]float32
+		for i, q := range testVector {
+			v[i] = q.Min + float32(r.Float64())*(q.Max-q.Min)
+		}
+		packed := testVector.Pack(v)
+		if packed>>testVector.Bits() != 0 {
+			t.Fatalf("%v packed to %#x, doesn't fit in %d bits", v, packed, testVector.Bits())
+		}
+		if got := testVector.Unpack(packed); got != testVector.Round(v) {
+			t.Fatalf("%v unpacked to %v, expected %v", v, got, testVector.Round(v))
+		}
+	}
+}
+
+func TestRotationRoundTrip(t *testing.T) {
+	r := rand.New(rand.NewSource(4))
+	for _, bits := range []int{8, 10, 12, 16} {
+		rotation := Rotation{Bits: bits}
+		// Each component is off by at most half a step, the rebuilt one by a few more.
+		maxErr := 4 * math.Sqrt2 / float64(int(1)<<bits-1)
+		for range 10000 {
+			q := normalize([4]float32{float32(r.NormFloat64()), float32(r.NormFloat64()), float32(r.NormFloat64()), float32(r.NormFloat64())})
+			got := rotation.Unpack(rotation.Pack(q))
+			// q and -q are the same rotation.
+			dot := float64(q[0]*got[0] + q[1]*got[1] + q[2]*got[2] + q[3]*got[3])
+			sign := float32(1)
+			if dot < 0 {
+				sign = -1
+			}
+			for i := range q {
+				if err := math.Abs(float64(got[i]*sign - q[i])); err > maxErr {
+					t.Fatalf("%d bits: %v unpacked to %v, component %d off by %v", bits, q, got, i, err)
+				}
+			}
+		}
+	}
+}
+
+func TestRotationIdentity(t *testing.T) {
+	rotation := Rotation{Bits: 10}
+	if got := rotation.Unpack(rotation.Pack([4]float32{0, 0, 0, 1})); got != [4]float32{0, 0, 0, 1} {
+		t.Fatalf("identity unpacked to %v", got)
+	}
+	if got := rotation.Unpack(rotation.Pack([4]float32{})); got != [4]float32{0, 0, 0, 1} {
+		t.Fatalf("zero quaternion unpacked to %v, expected the identity", got)
+	}
+}
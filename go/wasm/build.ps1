@@ -1,4 +1,7 @@
 $env:GOOS = "js"
 $env:GOARCH = "wasm"
-tinygo build -o ../../frontend/src/game/wasm/main.wasm ./main.go
+# VITE_WIRE_FORMAT=json builds in the JSON debug wire format, which the frontend then turns on.
+# Windows PowerShell drops empty arguments to native commands, so -tags is only passed along with a tag.
+$tags = if ($env:VITE_WIRE_FORMAT -eq "json") { @("-tags", "jsonwire") } else { @() }
+tinygo build @tags -o ../../frontend/src/game/wasm/main.wasm .
 Write-Output "WASM build complete"
\ No newline at end of file
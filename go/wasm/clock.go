@@ -0,0 +1,149 @@
+//go:build js && wasm
+
+package main
+
+import (
+	"math"
+	"slices"
+	"syscall/js"
+	"time"
+
+	"webgl-multiplayer/protocol"
+)
+
+const (
+	ClockSamples       = 16
+	ClockBurstSamples  = 5                      // requests sent right after connecting
+	ClockBurstInterval = 100 * time.Millisecond // between the burst's requests
+	ClockSyncInterval  = 2 * time.Second        // between requests once synced
+	ClockSnapThreshold = 100.0                  // milliseconds off before jumping rather than slewing
+	ClockMaxSlew       = 0.01                   // milliseconds corrected per elapsed millisecond
+)
+
+// clockSample is a single NTP-style round trip, in milliseconds.
+type clockSample struct {
+	offset float64 // server time - client time
+	delay  float64 // round trip minus the server's processing time
+}
+
+// Clock estimates the server's clock from sync round trips.
+// The applied offset slews towards the estimate gradually so server time never jumps around,
+// unless it's so far off that it needs to snap.
+type Clock struct {
+	samples []clockSample
+	synced  bool
+	target  float64 // best estimate of the offset from the samples
+	offset  float64 // offset currently applied, converging towards the target
+	updated float64 // local time the offset was last slewed
+
+	tickOrigin   float64 // server time of tick 0
+	tickInterval float64
+}
+
+var clock Clock
+
+// now is the local clock, in milliseconds.
+func now() float64 {
+	return js.Global().Get("performance").Call("now").Float()
+}
+
+// ServerTime is the estimated current server time, in milliseconds since the server started.
+func (c *Clock) ServerTime() float64 {
+	t := now()
+	if c.synced {
+		elapsed := t - c.updated
+		maxSlew := elapsed * ClockMaxSlew
+		c.offset += max(-maxSlew, min(maxSlew, c.target-c.offset))
+	}
+	c.updated = t
+	return t + c.offset
+}
+
+// ServerTick is the estimated tick the server is on, with the fraction elapsed towards the next one.
+func (c *Clock) ServerTick() float64 {
+	if c.tickInterval == 0 {
+		return 0
+	}
+	return (c.ServerTime() - c.tickOrigin) / c.tickInterval
+}
+
+// Synced reports whether the clock has received a sync response yet.
+func (c *Clock) Synced() bool {
+	return c.synced
+}
+
+// onTime adds the sample of a sync response received at the given local time.
+func (c *Clock) onTime(msg *protocol.Time, received float64) {
+	processing := msg.ServerSent - msg.ServerReceived
+	sample := clockSample{
+		offset: ((msg.ServerReceived - msg.ClientSent) + (msg.ServerSent - received)) / 2,
+		delay:  (received - msg.ClientSent) - processing,
+	}
+	if sample.delay < 0 {
+		return
+	}
+	c.samples = append(c.samples, sample)
+	if len(c.samples) > ClockSamples {
+		c.samples = c.samples[1:]
+	}
+	c.tickOrigin = msg.TickOrigin
+	c.tickInterval = msg.TickInterval
+
+	c.target = c.estimate()
+	if !c.synced || math.Abs(c.target-c.offset) > ClockSnapThreshold {
+		c.offset = c.target
+		c.updated = received
+		c.synced = true
+	}
+}
+
+// estimate averages the offsets of the fastest half of the samples.
+// That's the outlier rejection, the slower round trips are dropped since their offsets are skewed by queueing.
+func (c *Clock) estimate() float64 {
+	sorted := slices.Clone(c.samples)
+	slices.SortFunc(sorted, func(a, b clockSample) int {
+		if a.delay < b.delay {
+			return -1
+		} else if a.delay > b.delay {
+			return 1
+		}
+		return 0
+	})
+	kept := sorted[:max(1, (len(sorted)+1)/2)]
+	sum := 0.0
+	for _, s := range kept {
+		sum += s.offset
+	}
+	return sum / float64(len(kept))
+}
+
+// reset forgets the samples and the offset, the server's clock is different after reconnecting to a new server.
+// The clock isn't synced again until the first sample, which the offset then snaps to.
+func (c *Clock) reset() {
+	c.samples = c.samples[:0]
+	c.synced = false
+	c.target = 0
+	c.offset = 0
+}
+
+// syncClock sends a burst of sync requests, then keeps sending them on an interval until the socket closes.
+func syncClock(closed <-chan struct{}) {
+	for i := 0; i < ClockBurstSamples; i++ {
+		sendMessage(&protocol.TimeRequest{Sent: now()})
+		select {
+		case <-time.After(ClockBurstInterval):
+		case <-closed:
+			return
+		}
+	}
+	ticker := time.NewTicker(ClockSyncInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			sendMessage(&protocol.TimeRequest{Sent: now()})
+		case <-closed:
+			return
+		}
+	}
+}
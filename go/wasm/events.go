@@ -0,0 +1,66 @@
+//go:build js && wasm
+
+package main
+
+import (
+	"fmt"
+	"syscall/js"
+
+	"webgl-multiplayer/protocol"
+)
+
+var (
+	// lastEvent is the sequence number of the latest event applied, events up to it are skipped when they're resent.
+	lastEvent uint32
+	// receivedEvent is whether any event was applied yet in this session, sequence numbers start wherever the room is at.
+	receivedEvent bool
+)
+
+// resetEvents forgets the events of the previous session, after joining as a new client.
+func resetEvents() {
+	lastEvent = 0
+	receivedEvent = false
+}
+
+// onEvent applies a reliable event once, and acknowledges it.
+// Events the server resends after resuming may have been applied already on the previous connection.
+func onEvent(e *protocol.Event) {
+	// Compare with wraparound like ticks.
+	if !receivedEvent || int32(e.Seq-lastEvent) > 0 {
+		lastEvent = e.Seq
+		receivedEvent = true
+		applyEvent(e.Event)
+	}
+	sendMessage(&protocol.EventAck{Seq: lastEvent})
+}
+
+func applyEvent(m protocol.Message) {
+	switch m := m.(type) {
+	case *protocol.Roster:
+		roster = make(map[uint16]string, len(m.Clients))
+		for _, c := range m.Clients {
+			roster[c.Client] = c.Name
+		}
+	case *protocol.Joined:
+		roster[m.Client] = m.Name
+		fmt.Println(m.Name, " joined")
+	case *protocol.Left:
+		fmt.Println(roster[m.Client], " left")
+		delete(roster, m.Client)
+	case *protocol.Chat:
+		js.Global().Call("onChat", roster[m.Client], m.Text)
+	case *protocol.Kill:
+		fmt.Println(roster[m.Victim], " was killed by ", roster[m.Killer])
+	case *protocol.Pickup:
+		fmt.Println(roster[m.Client], " picked up item ", m.Item, " of kind ", m.Kind)
+	}
+}
+
+// sendChat sends a chat message, called from the worker as sendChat(text).
+func sendChat(this js.Value, args []js.Value) interface{} {
+	if len(args) != 1 {
+		return nil
+	}
+	sendMessage(&protocol.ChatRequest{Text: args[0].String()})
+	return nil
+}
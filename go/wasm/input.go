@@ -0,0 +1,93 @@
+//go:build js && wasm
+
+package main
+
+import (
+	"syscall/js"
+	"time"
+
+	"webgl-multiplayer/movement"
+	"webgl-multiplayer/protocol"
+)
+
+const (
+	// DefaultTickInterval paces input commands until the clock sync reports the server's tick interval, in milliseconds.
+	DefaultTickInterval = 1000.0 / 30
+	MaxPendingInputs    = 64 // commands kept for reconciliation at most, the oldest are dropped past this
+)
+
+// inputCommand is the player's controls for a single server tick.
+type inputCommand struct {
+	seq uint32
+	movement.Input
+}
+
+var (
+	// controls is the latest control state from the page, sampled once per tick into a command.
+	controls inputCommand
+	inputSeq uint32
+	// pendingInputs are the commands sent that the server hasn't applied yet, oldest first.
+	pendingInputs []inputCommand
+)
+
+// setInput updates the player's controls, called from the worker as setInput(buttons, yaw, pitch).
+func setInput(this js.Value, args []js.Value) interface{} {
+	if len(args) != 3 {
+		return nil
+	}
+	controls.Buttons = uint8(args[0].Int())
+	controls.Yaw = float32(args[1].Float())
+	controls.Pitch = float32(args[2].Float())
+	return nil
+}
+
+// sendInputs sends a command with the current controls every server tick, until the socket closes.
+func sendInputs(closed <-chan struct{}) {
+	interval := func() time.Duration {
+		ms := clock.tickInterval
+		if ms <= 0 {
+			ms = DefaultTickInterval
+		}
+		return time.Duration(ms * float64(time.Millisecond))
+	}
+	current := interval()
+	ticker := time.NewTicker(current)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-closed:
+			return
+		}
+		inputSeq++
+		in := controls
+		in.seq = inputSeq
+		in.Input = movement.ClampInput(in.Input)
+		pendingInputs = append(pendingInputs, in)
+		if len(pendingInputs) > MaxPendingInputs {
+			pendingInputs = pendingInputs[len(pendingInputs)-MaxPendingInputs:]
+		}
+		sendMessage(&protocol.Input{Seq: in.seq, Buttons: in.Buttons, Yaw: in.Yaw, Pitch: in.Pitch})
+		predict(in)
+
+		if next := interval(); next != current {
+			current = next
+			ticker.Reset(current)
+		}
+	}
+}
+
+// onInputAck drops the commands the server has applied to the player.
+func onInputAck(seq uint32) {
+	i := 0
+	for i < len(pendingInputs) && int32(pendingInputs[i].seq-seq) <= 0 {
+		i++
+	}
+	pendingInputs = pendingInputs[i:]
+}
+
+// resetInputs starts the commands over, for a new session whose player hasn't applied any yet.
+func resetInputs() {
+	inputSeq = 0
+	pendingInputs = nil
+}
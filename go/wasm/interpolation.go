@@ -0,0 +1,167 @@
+//go:build js && wasm
+
+package main
+
+import (
+	"encoding/binary"
+	"math"
+	"syscall/js"
+)
+
+const (
+	DefaultInterpolationDelay = 100.0 // milliseconds remote entities are rendered behind the server
+	MaxExtrapolation          = 250.0 // milliseconds entities keep moving by their velocity past the newest snapshot
+	TransformSize             = 7     // floats per published transform: position x, y, z, rotation x, y, z, w
+)
+
+// interpolationDelay is how far behind the estimated server time remote entities are rendered, in milliseconds.
+// It should cover a couple of ticks plus network jitter, so there's usually a snapshot on either side of the render time.
+var interpolationDelay = DefaultInterpolationDelay
+
+// setInterpolationDelay changes the interpolation delay, called from the worker as setInterpolationDelay(ms).
+func setInterpolationDelay(this js.Value, args []js.Value) interface{} {
+	if len(args) == 1 && args[0].Float() >= 0 {
+		interpolationDelay = args[0].Float()
+	}
+	return nil
+}
+
+// snapshotTime is the server time a snapshot's state is from, the end of its tick.
+func snapshotTime(tick uint32) float64 {
+	return clock.tickOrigin + float64(tick+1)*clock.tickInterval
+}
+
+// bracket finds the buffered snapshots on either side of the server time, either of which may be nil,
+// along with their times.
+func bracket(t float64) (before, after *snapshot, beforeTime, afterTime float64) {
+	for _, s := range snapshots {
+		if s == nil {
+			continue
+		}
+		st := snapshotTime(s.tick)
+		if st <= t {
+			if before == nil || st > beforeTime {
+				before, beforeTime = s, st
+			}
+		} else if after == nil || st < afterTime {
+			after, afterTime = s, st
+		}
+	}
+	return before, after, beforeTime, afterTime
+}
+
+// interpolate publishes the transform of every remote entity at the render time, called from the worker once per frame.
+// Between two snapshots positions are lerped and rotations slerped. Past the newest snapshot,
+// entities move on by their last velocity for a while before stopping, in case snapshots are only late.
+func interpolate(this js.Value, args []js.Value) interface{} {
+	if !clock.Synced() || latestSnapshot == nil {
+		return nil
+	}
+	t := clock.ServerTime() - interpolationDelay
+	before, after, beforeTime, afterTime := bracket(t)
+
+	ids := make([]uint32, 0, len(latestSnapshot.entities))
+	models := make([]byte, 0, len(latestSnapshot.entities))
+	transforms := make([]float32, 0, len(latestSnapshot.entities)*TransformSize)
+	publish := func(id uint32, e *entityState, position [3]float32, rotation [4]float32) {
+		if e.owner == session.id {
+			return // the local player is predicted instead
+		}
+		ids = append(ids, id)
+		models = append(models, e.model)
+		transforms = append(transforms, position[:]...)
+		transforms = append(transforms, rotation[:]...)
+	}
+
+	switch {
+	case before != nil && after != nil:
+		alpha := float32((t - beforeTime) / (afterTime - beforeTime))
+		for id, b := range after.entities {
+			a, ok := before.entities[id]
+			if !ok {
+				// Spawned since, there's nothing to interpolate from.
+				publish(id, &b, b.position, b.rotation)
+				continue
+			}
+			publish(id, &b, lerp(a.position, b.position, alpha), slerp(a.rotation, b.rotation, alpha))
+		}
+	case before != nil:
+		ahead := float32(min(t-beforeTime, MaxExtrapolation) / 1000)
+		for id, e := range before.entities {
+			var position [3]float32
+			for i := range 3 {
+				position[i] = e.position[i] + e.velocity[i]*ahead
+			}
+			publish(id, &e, position, e.rotation)
+		}
+	case after != nil:
+		// Every buffered snapshot is newer than the render time, show the oldest until time catches up.
+		for id, e := range after.entities {
+			publish(id, &e, e.position, e.rotation)
+		}
+	}
+
+	js.Global().Call("onEntities", uint32Array(ids), uint8Array(models), float32Array(transforms))
+	return nil
+}
+
+func lerp(a, b [3]float32, t float32) [3]float32 {
+	return [3]float32{
+		a[0] + (b[0]-a[0])*t,
+		a[1] + (b[1]-a[1])*t,
+		a[2] + (b[2]-a[2])*t,
+	}
+}
+
+// slerp interpolates between two unit quaternions along the shortest arc.
+func slerp(a, b [4]float32, t float32) [4]float32 {
+	dot := a[0]*b[0] + a[1]*b[1] + a[2]*b[2] + a[3]*b[3]
+	if dot < 0 {
+		// q and -q are the same rotation, flip one to take the short way around.
+		b = [4]float32{-b[0], -b[1], -b[2], -b[3]}
+		dot = -dot
+	}
+	var wa, wb float32
+	if dot > 0.9995 {
+		// Nearly parallel, where sin(theta) is too small to divide by, so fall back to a normalized lerp.
+		wa, wb = 1-t, t
+	} else {
+		theta := math.Acos(float64(dot))
+		sin := math.Sin(theta)
+		wa = float32(math.Sin((1-float64(t))*theta) / sin)
+		wb = float32(math.Sin(float64(t)*theta) / sin)
+	}
+	q := [4]float32{
+		wa*a[0] + wb*b[0],
+		wa*a[1] + wb*b[1],
+		wa*a[2] + wb*b[2],
+		wa*a[3] + wb*b[3],
+	}
+	l := float32(math.Sqrt(float64(q[0]*q[0] + q[1]*q[1] + q[2]*q[2] + q[3]*q[3])))
+	if l == 0 {
+		return b
+	}
+	return [4]float32{q[0] / l, q[1] / l, q[2] / l, q[3] / l}
+}
+
+func uint8Array(b []byte) js.Value {
+	a := js.Global().Get("Uint8Array").New(len(b))
+	js.CopyBytesToJS(a, b)
+	return a
+}
+
+func uint32Array(v []uint32) js.Value {
+	b := make([]byte, 0, len(v)*4)
+	for _, x := range v {
+		b = binary.LittleEndian.AppendUint32(b, x)
+	}
+	return js.Global().Get("Uint32Array").New(uint8Array(b).Get("buffer"))
+}
+
+func float32Array(v []float32) js.Value {
+	b := make([]byte, 0, len(v)*4)
+	for _, x := range v {
+		b = binary.LittleEndian.AppendUint32(b, math.Float32bits(x))
+	}
+	return js.Global().Get("Float32Array").New(uint8Array(b).Get("buffer"))
+}
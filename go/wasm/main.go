@@ -1,47 +1,195 @@
+//go:build js && wasm
+
 package main
 
 import (
+	"encoding/hex"
 	"fmt"
 	"syscall/js"
+	"time"
+
+	"webgl-multiplayer/protocol"
 )
 
+const (
+	ServerURL            = "ws://localhost:8080/ws"
+	ReconnectDelay       = time.Second
+	MaxReconnectAttempts = 5
+)
+
+// session is the client's identity on the server, kept to resume it after the connection drops.
+var session struct {
+	id    uint16
+	token string
+}
+
+var reconnectAttempts = 0
+
+// latestTick is the most recent server tick seen in a message.
+var latestTick uint32
+
+// roster is the display name of every client in the game, by client ID.
+var roster = make(map[uint16]string)
+
+// socketClosed is closed when the current socket closes, stopping its background loops.
+var socketClosed chan struct{}
+
+// jsonWire sends messages as JSON text frames, set by the wireFormat global being "json" when the module starts.
+// It's for reading traffic in the devtools, the server needs its -json-wire flag to accept them.
+// Messages from the server are decoded by their frame type either way.
+// Only builds with the jsonwire tag can send JSON, see protocol.JSONWire.
+var jsonWire bool
+
 func onSocketOpen(this js.Value, args []js.Value) interface{} {
 	fmt.Println("open")
+	reconnectAttempts = 0
+	socketClosed = make(chan struct{})
+	clock.reset()
+	go syncClock(socketClosed)
+	go sendInputs(socketClosed)
 	js.Global().Call("onSocketOpen")
 	return nil
 }
 
 func onSocketClose(this js.Value, args []js.Value) interface{} {
+	if socketClosed != nil {
+		close(socketClosed)
+		socketClosed = nil
+	}
+	switch reason := args[0].Get("reason").String(); reason {
+	case protocol.ReasonUpdateRequired:
+		// This build is too old for the server, reconnecting won't help until the page reloads with the new one.
+		js.Global().Call("onUpdateRequired")
+		return nil
+	case protocol.ReasonServerOutdated:
+		fmt.Println("server speaks an older protocol version than ", protocol.Version)
+	}
+	// Unclean closes are dropped connections rather than the server turning us away, so try to resume.
+	if !args[0].Get("wasClean").Bool() && session.token != "" && reconnectAttempts < MaxReconnectAttempts {
+		reconnectAttempts++
+		time.AfterFunc(ReconnectDelay, connect)
+		return nil
+	}
 	js.Global().Call("onSocketClose")
 	return nil
 }
 
 func onSocketMessage(this js.Value, args []js.Value) interface{} {
-	buf := js.Global().Get("Uint8Array").New(args[0].Get("data"))
-	data := make([]uint8, buf.Get("length").Int())
-	js.CopyBytesToGo(data, buf)
+	received := now()
+	var h protocol.Header
+	var m protocol.Message
+	var err error
+	if data := args[0].Get("data"); data.Type() == js.TypeString {
+		// Text frames are JSON, sent by a server with the JSON wire format on.
+		h, m, err = protocol.DecodeJSON([]byte(data.String()))
+	} else {
+		buf := js.Global().Get("Uint8Array").New(data)
+		b := make([]uint8, buf.Get("length").Int())
+		js.CopyBytesToGo(b, buf)
+		h, m, err = protocol.Decode(b)
+	}
+	if err != nil {
+		fmt.Println("invalid message: ", err)
+		return nil
+	}
+	// Compare with wraparound so the latest tick survives the counter overflowing.
+	if int32(h.Tick-latestTick) > 0 {
+		latestTick = h.Tick
+	}
+	switch m := m.(type) {
+	case *protocol.Welcome:
+		session.id = m.Client
+		session.token = hex.EncodeToString(m.Token[:])
+		if !m.Resumed {
+			resetSnapshots()
+			resetInputs()
+			resetPrediction()
+			resetEvents()
+		}
+		fmt.Println("joined as client ", m.Client, ", resumed: ", m.Resumed)
+	case *protocol.Time:
+		clock.onTime(m, received)
+	case *protocol.Snapshot:
+		onSnapshot(h.Tick, m)
+	case *protocol.Event:
+		onEvent(m)
+	}
 	// fmt.Println("received message: ", data)
 	return nil
 }
 
 var ws js.Value
 
+var socketOpenFunc, socketCloseFunc, socketMessageFunc, setInputFunc, interpolateFunc, setInterpolationDelayFunc, sendChatFunc js.Func
+
+// connect opens the socket, resuming the previous session if there is one.
+func connect() {
+	url := ServerURL
+	if session.token != "" {
+		url += "?resume=" + session.token
+	}
+	// Offer every protocol version this build speaks, the server picks one or closes with a reason saying who's out of date.
+	var subprotocols []any
+	for _, s := range protocol.Subprotocols() {
+		subprotocols = append(subprotocols, s)
+	}
+	ws = js.Global().Get("WebSocket").New(url, subprotocols)
+	ws.Set("binaryType", "arraybuffer")
+	ws.Call("addEventListener", "open", socketOpenFunc)
+	ws.Call("addEventListener", "close", socketCloseFunc)
+	ws.Call("addEventListener", "message", socketMessageFunc)
+}
+
+// sendMessage encodes a message and sends it to the server.
+func sendMessage(m protocol.Message) {
+	if !jsonWire {
+		SendSocketMessage(protocol.EncodeClient(m))
+		return
+	}
+	data, err := protocol.EncodeClientJSON(m)
+	if err != nil {
+		fmt.Println("encoding json: ", err)
+		return
+	}
+	if ws.Get("readyState").Int() != 1 { // WebSocket.OPEN
+		return
+	}
+	ws.Call("send", string(data))
+}
+
 func SendSocketMessage(data []uint8) {
-	// j.Call("send", data)
-	ws.Call("send", data)
+	if ws.Get("readyState").Int() != 1 { // WebSocket.OPEN
+		return
+	}
+	buf := js.Global().Get("Uint8Array").New(len(data))
+	js.CopyBytesToJS(buf, data)
+	ws.Call("send", buf)
 }
 
 func main() {
-	ws = js.Global().Get("WebSocket").New("ws://localhost:8080/ws")
-	ws.Set("binaryType", "arraybuffer")
-	ws.Call("addEventListener", "open", js.FuncOf(onSocketOpen))
-	ws.Call("addEventListener", "close", js.FuncOf(onSocketClose))
-	ws.Call("addEventListener", "message", js.FuncOf(onSocketMessage))
+	socketOpenFunc = js.FuncOf(onSocketOpen)
+	socketCloseFunc = js.FuncOf(onSocketClose)
+	socketMessageFunc = js.FuncOf(onSocketMessage)
+	setInputFunc = js.FuncOf(setInput)
+	js.Global().Set("setInput", setInputFunc)
+	interpolateFunc = js.FuncOf(interpolate)
+	js.Global().Set("interpolate", interpolateFunc)
+	setInterpolationDelayFunc = js.FuncOf(setInterpolationDelay)
+	js.Global().Set("setInterpolationDelay", setInterpolationDelayFunc)
+	sendChatFunc = js.FuncOf(sendChat)
+	js.Global().Set("sendChat", sendChatFunc)
+	if js.Global().Get("wireFormat").String() == "json" {
+		jsonWire = protocol.JSONWire
+		if !jsonWire {
+			fmt.Println("the json wire format needs a build with the jsonwire tag, sending binary")
+		}
+	}
+	connect()
 
 	defer func() {
-		ws.Call("removeEventListener", "open", js.FuncOf(onSocketOpen))
-		ws.Call("removeEventListener", "close", js.FuncOf(onSocketClose))
-		ws.Call("removeEventListener", "message", js.FuncOf(onSocketMessage))
+		ws.Call("removeEventListener", "open", socketOpenFunc)
+		ws.Call("removeEventListener", "close", socketCloseFunc)
+		ws.Call("removeEventListener", "message", socketMessageFunc)
 	}()
 
 	// js.Global().Set("formatJSON", jsonWrapper())
@@ -0,0 +1,72 @@
+//go:build js && wasm
+
+package main
+
+import (
+	"math"
+	"syscall/js"
+	"time"
+
+	"webgl-multiplayer/movement"
+	"webgl-multiplayer/protocol"
+)
+
+var (
+	// predicted is the local player after every command sent so far, valid once the server replicated the player.
+	predicted    movement.State
+	hasPredicted bool
+)
+
+// tickSeconds is the server's tick interval in seconds, rebuilt from the synced milliseconds
+// so it's the exact time step the server simulates.
+func tickSeconds() float32 {
+	ms := clock.tickInterval
+	if ms <= 0 {
+		ms = DefaultTickInterval
+	}
+	return float32(time.Duration(math.Round(ms * float64(time.Millisecond))).Seconds())
+}
+
+// predict applies a command to the local player as soon as it's sent, rather than waiting for the server.
+func predict(in inputCommand) {
+	if !hasPredicted {
+		return
+	}
+	step(in, tickSeconds())
+	postPlayer()
+}
+
+// step moves the predicted player by a command, rounding it to what the server replicates like the server does.
+func step(in inputCommand, dt float32) {
+	movement.Step(&predicted, in.Input, &movement.DefaultLevel, dt)
+	predicted.Position = protocol.Position.Round(predicted.Position)
+	predicted.Velocity = protocol.Velocity.Round(predicted.Velocity)
+}
+
+// reconcile rewinds the local player to the server's state, then replays the commands the server hasn't applied yet.
+// Pending inputs must already exclude the commands included in the server's state.
+// The renderer only hears about it if the prediction was off.
+func reconcile(server entityState) {
+	before, had := predicted, hasPredicted
+	predicted = movement.State{Position: server.position, Velocity: server.velocity}
+	hasPredicted = true
+	dt := tickSeconds()
+	for _, in := range pendingInputs {
+		step(in, dt)
+	}
+	if !had || predicted != before {
+		postPlayer()
+	}
+}
+
+// resetPrediction forgets the local player, until the server replicates it again.
+func resetPrediction() {
+	predicted = movement.State{}
+	hasPredicted = false
+}
+
+// postPlayer hands the predicted local player's eye position to the renderer, along with the time it takes to get there.
+func postPlayer() {
+	p := predicted.Position
+	js.Global().Call("onPlayerMoved", p[0], p[1]+movement.EyeHeight, p[2], float64(tickSeconds())*1000)
+}
@@ -0,0 +1,111 @@
+//go:build js && wasm
+
+package main
+
+import (
+	"maps"
+
+	"webgl-multiplayer/protocol"
+)
+
+// SnapshotBufferSize is the number of received snapshots kept as baselines for the server's deltas.
+// This must match the backend's.
+const SnapshotBufferSize = 32
+
+// entityState is an entity as the server last replicated it.
+type entityState struct {
+	owner    uint16 // client ID, protocol.NoOwner if the entity has no owner
+	model    uint8
+	position [3]float32
+	rotation [4]float32 // quaternion x, y, z, w
+	velocity [3]float32
+	inputSeq uint32 // owner's last input command the server applied
+}
+
+// snapshot is the world state at the end of a server tick, by entity ID.
+type snapshot struct {
+	tick     uint32
+	entities map[uint32]entityState
+}
+
+var (
+	snapshots      [SnapshotBufferSize]*snapshot // by tick modulo the buffer size
+	latestSnapshot *snapshot
+)
+
+// findSnapshot returns the received snapshot of the given tick, if it's still buffered.
+func findSnapshot(tick uint32) *snapshot {
+	s := snapshots[tick%SnapshotBufferSize]
+	if s == nil || s.tick != tick {
+		return nil
+	}
+	return s
+}
+
+// applySnapshot applies a snapshot's changes to its baseline.
+// It fails if the baseline isn't in the buffer anymore.
+func applySnapshot(tick uint32, m *protocol.Snapshot) (*snapshot, bool) {
+	s := &snapshot{tick: tick}
+	if m.Baseline == tick {
+		s.entities = make(map[uint32]entityState, len(m.Entities))
+	} else {
+		baseline := findSnapshot(m.Baseline)
+		if baseline == nil {
+			return nil, false
+		}
+		s.entities = maps.Clone(baseline.entities)
+	}
+	for i := range m.Entities {
+		u := &m.Entities[i]
+		e := s.entities[u.ID]
+		if u.Fields&protocol.FieldOwner != 0 {
+			e.owner = u.Owner
+		}
+		if u.Fields&protocol.FieldModel != 0 {
+			e.model = u.Model
+		}
+		if u.Fields&protocol.FieldPosition != 0 {
+			e.position = u.Position
+		}
+		if u.Fields&protocol.FieldRotation != 0 {
+			e.rotation = u.Rotation
+		}
+		if u.Fields&protocol.FieldVelocity != 0 {
+			e.velocity = u.Velocity
+		}
+		if u.Fields&protocol.FieldInputSeq != 0 {
+			e.inputSeq = u.InputSeq
+		}
+		s.entities[u.ID] = e
+	}
+	for _, id := range m.Removed {
+		delete(s.entities, id)
+	}
+	return s, true
+}
+
+// onSnapshot stores a snapshot and acknowledges it so the server uses it as the baseline for the next deltas.
+// Snapshots whose baseline is gone are dropped, the server falls back to a full snapshot once it notices.
+func onSnapshot(tick uint32, m *protocol.Snapshot) {
+	s, ok := applySnapshot(tick, m)
+	if !ok {
+		return
+	}
+	snapshots[tick%SnapshotBufferSize] = s
+	if latestSnapshot == nil || int32(tick-latestSnapshot.tick) > 0 {
+		latestSnapshot = s
+		sendMessage(&protocol.Ack{Tick: tick})
+		for _, e := range s.entities {
+			if e.owner == session.id {
+				onInputAck(e.inputSeq)
+				reconcile(e)
+			}
+		}
+	}
+}
+
+// resetSnapshots forgets every snapshot, for a new session whose deltas start over from a full snapshot.
+func resetSnapshots() {
+	snapshots = [SnapshotBufferSize]*snapshot{}
+	latestSnapshot = nil
+}
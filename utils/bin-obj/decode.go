@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// Component mask bits, set for the optional components every vertex has.
+const (
+	componentUV     uint8 = 1
+	componentNormal uint8 = 2
+	componentColor  uint8 = 4
+)
+
+// headerSize is the size of the .bobj header: index size, component mask, scale, center, and the two counts.
+const headerSize = 1 + 1 + 3*8 + 3*4 + 4 + 4
+
+// Model is a decoded .bobj file, with its vertices left packed the way they're encoded.
+type Model struct {
+	IndexSize     uint8
+	ComponentMask uint8
+	Scale         [3]float64
+	Center        [3]float32
+	Vertices      []uint32 // vertexWords per vertex
+	Indices       []uint32
+}
+
+var errTruncated = errors.New("bobj: file truncated")
+
+// vertexWords is the number of uint32s each vertex is packed in: position and color, then the normal and uv if present.
+func vertexWords(componentMask uint8) int {
+	n := 2
+	if componentMask&componentNormal != 0 {
+		n++
+	}
+	if componentMask&componentUV != 0 {
+		n++
+	}
+	return n
+}
+
+// decodeModel decodes a .bobj file, checking every count against the size of the file
+// and every index against the number of vertices, so a corrupt file fails instead of reading out of bounds.
+func decodeModel(data []byte) (*Model, error) {
+	if len(data) < headerSize {
+		return nil, errTruncated
+	}
+	m := &Model{IndexSize: data[0], ComponentMask: data[1]}
+	if m.IndexSize != 1 && m.IndexSize != 2 && m.IndexSize != 4 {
+		return nil, fmt.Errorf("bobj: invalid index size %d", m.IndexSize)
+	}
+	if m.ComponentMask&^(componentUV|componentNormal|componentColor) != 0 {
+		return nil, fmt.Errorf("bobj: invalid component mask %#x", m.ComponentMask)
+	}
+	offset := 2
+	for i := range m.Scale {
+		m.Scale[i] = math.Float64frombits(binary.LittleEndian.Uint64(data[offset:]))
+		offset += 8
+	}
+	for i := range m.Center {
+		m.Center[i] = math.Float32frombits(binary.LittleEndian.Uint32(data[offset:]))
+		offset += 4
+	}
+	words := binary.LittleEndian.Uint32(data[offset:])
+	indexCount := binary.LittleEndian.Uint32(data[offset+4:])
+	offset += 8
+
+	stride := vertexWords(m.ComponentMask)
+	if words%uint32(stride) != 0 {
+		return nil, fmt.Errorf("bobj: vertex buffer of %d words isn't a whole number of %d word vertices", words, stride)
+	}
+	if indexCount%3 != 0 {
+		return nil, fmt.Errorf("bobj: %d indices aren't a whole number of triangles", indexCount)
+	}
+	// The counts come from the file, so the size they add up to is computed without overflowing before comparing.
+	if size := uint64(headerSize) + 4*uint64(words) + uint64(m.IndexSize)*uint64(indexCount); size != uint64(len(data)) {
+		if size > uint64(len(data)) {
+			return nil, errTruncated
+		}
+		return nil, fmt.Errorf("bobj: %d unexpected bytes after the indices", uint64(len(data))-size)
+	}
+
+	m.Vertices = make([]uint32, words)
+	for i := range m.Vertices {
+		m.Vertices[i] = binary.LittleEndian.Uint32(data[offset:])
+		offset += 4
+	}
+	vertexCount := words / uint32(stride)
+	m.Indices = make([]uint32, indexCount)
+	for i := range m.Indices {
+		var index uint32
+		switch m.IndexSize {
+		case 1:
+			index = uint32(data[offset])
+		case 2:
+			index = uint32(binary.LittleEndian.Uint16(data[offset:]))
+		case 4:
+			index = binary.LittleEndian.Uint32(data[offset:])
+		}
+		offset += int(m.IndexSize)
+		if index >= vertexCount {
+			return nil, fmt.Errorf("bobj: index %d of %d vertices", index, vertexCount)
+		}
+		m.Indices[i] = index
+	}
+	return m, nil
+}
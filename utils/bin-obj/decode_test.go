@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// models are the converted models the frontend serves.
+var models, _ = filepath.Glob("../../frontend/static/*.bobj")
+
+// encodeModel encodes a model the way main writes it.
+func encodeModel(m *Model) []byte {
+	b := []byte{m.IndexSize, m.ComponentMask}
+	for _, s := range m.Scale {
+		b = binary.LittleEndian.AppendUint64(b, math.Float64bits(s))
+	}
+	for _, c := range m.Center {
+		b = binary.LittleEndian.AppendUint32(b, math.Float32bits(c))
+	}
+	b = binary.LittleEndian.AppendUint32(b, uint32(len(m.Vertices)))
+	b = binary.LittleEndian.AppendUint32(b, uint32(len(m.Indices)))
+	for _, v := range m.Vertices {
+		b = binary.LittleEndian.AppendUint32(b, v)
+	}
+	for _, i := range m.Indices {
+		switch m.IndexSize {
+		case 1:
+			b = append(b, uint8(i))
+		case 2:
+			b = binary.LittleEndian.AppendUint16(b, uint16(i))
+		case 4:
+			b = binary.LittleEndian.AppendUint32(b, i)
+		}
+	}
+	return b
+}
+
+// triangle is a single triangle with every component, with 8 bit indices.
+var triangle = &Model{
+	IndexSize:     1,
+	ComponentMask: componentColor | componentNormal | componentUV,
+	Scale:         [3]float64{1, 0.5, 0.25},
+	Center:        [3]float32{0, 1, -1},
+	Vertices:      []uint32{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12},
+	Indices:       []uint32{0, 1, 2},
+}
+
+func TestDecodeModel(t *testing.T) {
+	if len(models) == 0 {
+		t.Fatal("no models in frontend/static")
+	}
+	for _, path := range models {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		m, err := decodeModel(data)
+		if err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+		if len(m.Indices) == 0 {
+			t.Fatalf("%s: no triangles", path)
+		}
+		if !bytes.Equal(encodeModel(m), data) {
+			t.Fatalf("%s: encodes differently after decoding", path)
+		}
+	}
+
+	data := encodeModel(triangle)
+	for n := range len(data) {
+		if _, err := decodeModel(data[:n]); err == nil {
+			t.Fatalf("decoded the first %d bytes without an error", n)
+		}
+	}
+	if _, err := decodeModel(append(data, 0)); err == nil {
+		t.Fatal("decoded with a trailing byte")
+	}
+	outOfBounds := *triangle
+	outOfBounds.Indices = []uint32{0, 1, 3}
+	if _, err := decodeModel(encodeModel(&outOfBounds)); err == nil {
+		t.Fatal("decoded an index past the last vertex")
+	}
+}
+
+// FuzzDecodeModel decodes arbitrary files. Decoding must never panic,
+// and a file that decodes must only index its own vertices and encode back to the same bytes.
+func FuzzDecodeModel(f *testing.F) {
+	for _, path := range models {
+		// The larger models are slow to mutate, the fuzzer gets more out of the small ones.
+		if data, err := os.ReadFile(path); err == nil && len(data) < 64<<10 {
+			f.Add(data)
+		}
+	}
+	f.Add(encodeModel(triangle))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		m, err := decodeModel(data)
+		if err != nil {
+			return
+		}
+		vertices := len(m.Vertices) / vertexWords(m.ComponentMask)
+		for _, i := range m.Indices {
+			if int(i) >= vertices {
+				t.Fatalf("index %d of %d vertices", i, vertices)
+			}
+		}
+		if !bytes.Equal(encodeModel(m), data) {
+			t.Fatal("encodes differently after decoding")
+		}
+	})
+}
@@ -326,14 +326,14 @@ func main() {
 	componentMask := uint8(0)
 	vertexPackedLen := 2
 	if hasColor {
-		componentMask |= 4
+		componentMask |= componentColor
 	}
 	if hasNormal {
-		componentMask |= 2
+		componentMask |= componentNormal
 		vertexPackedLen += 1
 	}
 	if hasUV {
-		componentMask |= 1
+		componentMask |= componentUV
 		vertexPackedLen += 1
 	}
 
@@ -378,6 +378,20 @@ func main() {
 		writeIndices(output, indices, func(i uint32) uint32 { return i })
 	}
 
+	// Read the output back to check it decodes like the frontend will decode it.
+	written, err := os.ReadFile(out)
+	if err != nil {
+		fmt.Printf("Error reading output file: %v\n", err)
+		os.Exit(1)
+	}
+	if _, err := decodeModel(written); err != nil {
+		fmt.Printf("Error: wrote an invalid file: %v\n", err)
+		// Don't leave the invalid file behind for the frontend to load.
+		output.Close()
+		os.Remove(out)
+		os.Exit(1)
+	}
+
 	path, err := filepath.Abs(out)
 	if err != nil {
 		fmt.Printf("Error getting path of output file: %v\n", err)